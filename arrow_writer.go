@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/briandowns/spinner"
+)
+
+// Number of rows per Arrow record batch
+const arrowBatchSize = 8192
+
+// Arrow schema matching ParquetRecord (all columns)
+var fullArrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "record_type", Type: arrow.BinaryTypes.String},
+	{Name: "cluster_number", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "identity", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "strand", Type: arrow.BinaryTypes.String},
+	{Name: "unused_1", Type: arrow.BinaryTypes.String},
+	{Name: "unused_2", Type: arrow.BinaryTypes.String},
+	{Name: "cigar", Type: arrow.BinaryTypes.String},
+	{Name: "query", Type: arrow.BinaryTypes.String},
+	{Name: "target", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// Arrow schema matching MapRecord
+var mapArrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "query", Type: arrow.BinaryTypes.String},
+	{Name: "target", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// Common interface of the Arrow IPC file and stream writers
+type arrowRecordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// Process UC-file and write output as Arrow IPC file (Feather v2) or stream
+func processAndWriteArrow(input *os.File, w io.Writer, opts Options, s *spinner.Spinner, stream bool) error {
+	reader, err := openRecordReader(input, opts.inputFile, opts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	schema := fullArrowSchema
+	if opts.mapOnly {
+		schema = mapArrowSchema
+	}
+
+	var writer arrowRecordWriter
+	if stream {
+		writer = ipc.NewWriter(w, ipc.WithSchema(schema))
+	} else {
+		writer, err = ipc.NewFileWriter(w, ipc.WithSchema(schema))
+		if err != nil {
+			return newUCError("IO", "failed to create arrow writer", err)
+		}
+	}
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	// Write the rows accumulated in the builder as one record batch
+	rows := 0
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+		rec := builder.NewRecord()
+		defer rec.Release()
+		rows = 0
+		return writer.Write(rec)
+	}
+
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		appendArrowRecord(builder, record, opts.mapOnly)
+		rows++
+		if rows == arrowBatchSize {
+			return flush()
+		}
+		return nil
+	}, s)
+	if err == nil {
+		err = flush()
+	}
+
+	// Closing writes the end-of-stream marker or the file footer
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = newUCError("IO", "failed to close arrow writer", closeErr)
+	}
+	return err
+}
+
+// Append a record to the builder using the Parquet column conventions
+func appendArrowRecord(builder *array.RecordBuilder, record UCRecord, mapOnly bool) {
+	if mapOnly {
+		builder.Field(0).(*array.StringBuilder).Append(record.Query)
+		builder.Field(1).(*array.StringBuilder).Append(record.Target)
+		return
+	}
+
+	p := record.ToParquet()
+	builder.Field(0).(*array.StringBuilder).Append(p.RecordType)
+	builder.Field(1).(*array.Uint32Builder).Append(p.ClusterNumber)
+	builder.Field(2).(*array.Uint32Builder).Append(p.Size)
+	if p.Identity != nil {
+		builder.Field(3).(*array.Float64Builder).Append(*p.Identity)
+	} else {
+		builder.Field(3).(*array.Float64Builder).AppendNull()
+	}
+	builder.Field(4).(*array.StringBuilder).Append(p.Strand)
+	builder.Field(5).(*array.StringBuilder).Append(p.Unused1)
+	builder.Field(6).(*array.StringBuilder).Append(p.Unused2)
+	builder.Field(7).(*array.StringBuilder).Append(p.CIGAR)
+	builder.Field(8).(*array.StringBuilder).Append(p.Query)
+	builder.Field(9).(*array.StringBuilder).Append(p.Target)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Distance between the OTU abundance vectors of two samples
+type distanceFunc func(x, y []float64) float64
+
+// Beta-diversity metrics by name
+var betaMetrics = map[string]distanceFunc{
+	"braycurtis": brayCurtis,
+	"jaccard":    jaccard,
+	"aitchison":  euclidean, // On CLR-transformed abundances
+}
+
+// Bray-Curtis dissimilarity
+func brayCurtis(x, y []float64) float64 {
+	var diff, sum float64
+	for i := range x {
+		diff += math.Abs(x[i] - y[i])
+		sum += x[i] + y[i]
+	}
+	if sum == 0 {
+		return 0
+	}
+	return diff / sum
+}
+
+// Jaccard distance of OTU presence/absence
+func jaccard(x, y []float64) float64 {
+	var shared, union float64
+	for i := range x {
+		if x[i] > 0 || y[i] > 0 {
+			union++
+			if x[i] > 0 && y[i] > 0 {
+				shared++
+			}
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return 1 - shared/union
+}
+
+func euclidean(x, y []float64) float64 {
+	var sum float64
+	for i := range x {
+		d := x[i] - y[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// Centered log-ratio transform with a pseudocount for zero abundances
+func clr(x []float64, pseudocount float64) []float64 {
+	logs := make([]float64, len(x))
+	mean := 0.0
+	for i, v := range x {
+		logs[i] = math.Log(v + pseudocount)
+		mean += logs[i]
+	}
+	mean /= float64(len(x))
+	for i := range logs {
+		logs[i] -= mean
+	}
+	return logs
+}
+
+// Abundance vectors of all samples over the same (sorted) set of OTUs
+func (c *sampleCounts) matrix() [][]float64 {
+	seen := make(map[string]struct{})
+	var targets []string
+	for _, sample := range c.samples {
+		for target := range c.counts[sample] {
+			if _, exists := seen[target]; !exists {
+				seen[target] = struct{}{}
+				targets = append(targets, target)
+			}
+		}
+	}
+	slices.Sort(targets)
+
+	rows := make([][]float64, len(c.samples))
+	for i, sample := range c.samples {
+		rows[i] = make([]float64, len(targets))
+		for j, target := range targets {
+			rows[i][j] = c.counts[sample][target]
+		}
+	}
+	return rows
+}
+
+// Pairwise distances between all samples
+func distanceMatrix(c *sampleCounts, metric string, pseudocount float64) ([][]float64, error) {
+	distance, ok := betaMetrics[metric]
+	if !ok {
+		return nil, newUCError("Argument", fmt.Sprintf("unknown beta-diversity metric %q", metric), nil)
+	}
+
+	rows := c.matrix()
+	if metric == "aitchison" {
+		if pseudocount <= 0 {
+			return nil, newUCError("Argument", "Aitchison distance requires a positive pseudocount", nil)
+		}
+		for i := range rows {
+			rows[i] = clr(rows[i], pseudocount)
+		}
+	}
+
+	n := len(rows)
+	distances := make([][]float64, n)
+	for i := range distances {
+		distances[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := distance(rows[i], rows[j])
+			distances[i][j], distances[j][i] = d, d
+		}
+	}
+	return distances, nil
+}
+
+// Compute a beta-diversity distance matrix between samples
+func runBeta(args []string) error {
+	opts := diversityOptions{}
+	var metric, format string
+	var pseudocount float64
+	fs := flag.NewFlagSet("beta", flag.ExitOnError)
+
+	flagPairs := append(diversityFlags(&opts),
+		flagPair{"metric", "", &metric, "Distance metric: braycurtis, jaccard, aitchison", "braycurtis"},
+		flagPair{"pseudocount", "", &pseudocount, "Pseudocount added to abundances for the Aitchison distance", 1.0},
+		flagPair{"format", "f", &format, "Output format: tsv, phylip (default: from output file extension)", ""},
+	)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs beta -i <input.uc.gz> --sample-map <samples.tsv> --metric braycurtis -o <distances.tsv>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	if format == "" {
+		format = "tsv"
+		if strings.HasSuffix(opts.outputFile, ".phy") || strings.HasSuffix(opts.outputFile, ".phylip") {
+			format = "phylip"
+		}
+	}
+	var write func(io.Writer, []string, [][]float64) error
+	switch format {
+	case "tsv":
+		write = writeDistancesTSV
+	case "phylip":
+		write = writeDistancesPhylip
+	default:
+		return newUCError("Argument", fmt.Sprintf("unknown distance matrix format %q", format), nil)
+	}
+	if _, ok := betaMetrics[metric]; !ok {
+		return newUCError("Argument", fmt.Sprintf("unknown beta-diversity metric %q", metric), nil)
+	}
+
+	counts, err := loadSampleCounts(opts)
+	if err != nil {
+		return err
+	}
+	distances, err := distanceMatrix(counts, metric, pseudocount)
+	if err != nil {
+		return err
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := write(writer, counts.samples, distances); err != nil {
+		return newUCError("IO", "failed to write distance matrix", err)
+	}
+	return writer.Flush()
+}
+
+// Square TSV with sample names in the header and first column
+func writeDistancesTSV(w io.Writer, samples []string, distances [][]float64) error {
+	if _, err := fmt.Fprintf(w, "Sample\t%s\n", strings.Join(samples, "\t")); err != nil {
+		return err
+	}
+	for i, sample := range samples {
+		if _, err := io.WriteString(w, sample); err != nil {
+			return err
+		}
+		for _, d := range distances[i] {
+			if _, err := fmt.Fprintf(w, "\t%.6f", d); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Square PHYLIP distance matrix (relaxed: names are padded, not truncated, to 10 characters)
+func writeDistancesPhylip(w io.Writer, samples []string, distances [][]float64) error {
+	if _, err := fmt.Fprintf(w, "%d\n", len(samples)); err != nil {
+		return err
+	}
+	for i, sample := range samples {
+		if _, err := fmt.Fprintf(w, "%-10s", sample); err != nil {
+			return err
+		}
+		for _, d := range distances[i] {
+			if _, err := fmt.Fprintf(w, " %.6f", d); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Value type of an output column
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	uintColumn
+	floatColumn
+)
+
+// Output column with a typed accessor; nil values mark missing ("*") fields
+type ucColumn struct {
+	name     string
+	typ      columnType
+	nullable bool
+	value    func(UCRecord) any
+}
+
+// All record columns, in UC field order, named as in the Parquet output
+var ucColumns = []ucColumn{
+	{"record_type", stringColumn, false, func(r UCRecord) any { return r.RecordType }},
+	{"cluster_number", uintColumn, false, func(r UCRecord) any { return r.ClusterNumber }},
+	{"size", uintColumn, false, func(r UCRecord) any { return r.Size }},
+	{"identity", floatColumn, true, func(r UCRecord) any {
+		if r.Identity == nil {
+			return nil
+		}
+		return *r.Identity
+	}},
+	{"strand", stringColumn, true, func(r UCRecord) any {
+		if r.Strand == nil {
+			return nil
+		}
+		return string(*r.Strand)
+	}},
+	{"unused_1", stringColumn, false, func(r UCRecord) any { return r.Unused1 }},
+	{"unused_2", stringColumn, false, func(r UCRecord) any { return r.Unused2 }},
+	{"cigar", stringColumn, false, func(r UCRecord) any { return r.CIGAR }},
+	{"query", stringColumn, false, func(r UCRecord) any { return r.Query }},
+	{"target", stringColumn, false, func(r UCRecord) any { return r.Target }},
+}
+
+// Columns derived from sequence label annotations (e.g., "seq1;size=10;sample=A")
+// or from the sample map
+var derivedColumns = []ucColumn{
+	{"query_size", uintColumn, true, func(r UCRecord) any { return labelSize(r.QueryLabel) }},
+	{"target_size", uintColumn, true, func(r UCRecord) any { return labelSize(r.TargetLabel) }},
+	{"sample", stringColumn, true, func(r UCRecord) any {
+		if sample, ok := recordSample(r); ok {
+			return sample
+		}
+		return nil
+	}},
+	{"weight", floatColumn, true, func(r UCRecord) any {
+		if r.Weight == nil {
+			return nil
+		}
+		return *r.Weight
+	}},
+}
+
+// Columns available in addition to the record fields (e.g., weight, taxonomy)
+func extraColumns(opts Options) []ucColumn {
+	var columns []ucColumn
+	if opts.resolve == resolveFractional {
+		weight, _ := findColumn("weight", nil)
+		columns = append(columns, weight)
+	}
+	if opts.taxonomy != nil {
+		columns = append(columns, opts.taxonomy.columns()...)
+	}
+	return columns
+}
+
+// Check whether output columns differ from the default layout
+func customColumns(opts Options) bool {
+	return opts.columns != "" || len(extraColumns(opts)) > 0
+}
+
+// Check that the output format can hold selected and extra columns
+func checkColumns(format string, opts Options) error {
+	switch format {
+	case "uc", "sqlite", "arrow", "arrow-stream":
+		if opts.columns != "" || opts.taxonomyFile != "" {
+			return newUCError("Argument", fmt.Sprintf("%s output does not support --columns or --taxonomy", format), nil)
+		}
+		if opts.resolve == resolveFractional {
+			return newUCError("Argument", fmt.Sprintf("%s output has no weight column for --resolve fractional", format), nil)
+		}
+	}
+	return nil
+}
+
+// Look up a column by name
+func findColumn(name string, extra []ucColumn) (ucColumn, bool) {
+	for _, columns := range [][]ucColumn{ucColumns, derivedColumns, extra} {
+		for _, c := range columns {
+			if c.name == name {
+				return c, true
+			}
+		}
+	}
+	return ucColumn{}, false
+}
+
+// Default columns for map-only and full record output
+func defaultColumns(mapOnly bool) []ucColumn {
+	if mapOnly {
+		query, _ := findColumn("query", nil)
+		target, _ := findColumn("target", nil)
+		return []ucColumn{query, target}
+	}
+	return ucColumns
+}
+
+// Header names of the default layout, as in the text output of full and map-only records
+var (
+	fullHeaderNames = map[string]string{
+		"record_type":    "recordType",
+		"cluster_number": "clusterNumber",
+		"unused_1":       "unused1",
+		"unused_2":       "unused2",
+	}
+	mapHeaderNames = map[string]string{
+		"query":  "Query",
+		"target": "Target",
+	}
+)
+
+// Header names shared by text and CSV output: selected columns keep their names,
+// default columns are named as in the default layout, followed by extra columns
+func columnHeader(columns []ucColumn, opts Options) []string {
+	headerNames := fullHeaderNames
+	if opts.mapOnly {
+		headerNames = mapHeaderNames
+	}
+
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+		if name, ok := headerNames[c.name]; ok && opts.columns == "" {
+			names[i] = name
+		}
+	}
+	return names
+}
+
+// Parse a comma-separated list of column names.
+// Without a list, extra columns are appended to the default columns.
+func parseColumns(list string, opts Options) ([]ucColumn, error) {
+	extra := extraColumns(opts)
+	if list == "" {
+		return append(slices.Clip(defaultColumns(opts.mapOnly)), extra...), nil
+	}
+
+	var columns []ucColumn
+	seen := make(map[string]struct{})
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		c, ok := findColumn(name, extra)
+		if !ok {
+			return nil, newUCError("Argument", fmt.Sprintf("unknown column %q", name), nil)
+		}
+		if _, exists := seen[name]; exists {
+			return nil, newUCError("Argument", fmt.Sprintf("duplicate column %q", name), nil)
+		}
+		seen[name] = struct{}{}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// Format a column value as text, shared by text and CSV output:
+// missing values become "*", identities have two decimals
+func (c ucColumn) text(record UCRecord) string {
+	switch v := c.value(record).(type) {
+	case nil:
+		return "*"
+	case string:
+		return v
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case float64:
+		if c.name == "identity" {
+			return strconv.FormatFloat(v, 'f', 2, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Find the value of a "key=value" annotation in a sequence label
+func labelAnnotation(label, key string) (string, bool) {
+	parts := strings.Split(label, ";")
+	for _, part := range parts[1:] {
+		if value, ok := strings.CutPrefix(part, key+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Abundance from the "size=" annotation, nil if absent
+func labelSize(label string) any {
+	value, ok := labelAnnotation(label, "size")
+	if !ok {
+		return nil
+	}
+	size, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil
+	}
+	return uint32(size)
+}
+
+// Sample name from the "sample=" (or legacy "barcodelabel=") annotation
+func labelSample(label string) (string, bool) {
+	if sample, ok := labelAnnotation(label, "sample"); ok {
+		return sample, true
+	}
+	return labelAnnotation(label, "barcodelabel")
+}
+
+// Build a Parquet schema for the selected columns, preserving their order
+func parquetSchema(columns []ucColumn) *parquet.Schema {
+	fields := make([]reflect.StructField, len(columns))
+	for i, c := range columns {
+		var t reflect.Type
+		switch c.typ {
+		case uintColumn:
+			t = reflect.TypeOf(uint32(0))
+		case floatColumn:
+			t = reflect.TypeOf(float64(0))
+		default:
+			t = reflect.TypeOf("")
+		}
+		// Pointer fields become optional (nullable) columns
+		if c.nullable {
+			t = reflect.PointerTo(t)
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Column%d", i),
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`parquet:%q`, c.name)),
+		}
+	}
+	return parquet.SchemaOf(reflect.New(reflect.StructOf(fields)).Interface())
+}
+
+// Convert a record into a Parquet row following the column schema
+func parquetRow(row parquet.Row, record UCRecord, columns []ucColumn) parquet.Row {
+	row = row[:0]
+	for i, c := range columns {
+		v := c.value(record)
+		switch {
+		case v == nil:
+			row = append(row, parquet.NullValue().Level(0, 0, i))
+		case c.nullable:
+			row = append(row, parquet.ValueOf(v).Level(0, 1, i))
+		default:
+			row = append(row, parquet.ValueOf(v).Level(0, 0, i))
+		}
+	}
+	return row
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options of the components command
+type componentsOptions struct {
+	inputOptions
+	inputFile  string
+	outputFile string
+	all        bool
+	splitSeqID bool
+}
+
+// Union-find over target IDs
+type targetSets struct {
+	parent map[string]string
+	size   map[string]int
+}
+
+func newTargetSets() *targetSets {
+	return &targetSets{parent: make(map[string]string), size: make(map[string]int)}
+}
+
+func (u *targetSets) add(target string) {
+	if _, exists := u.parent[target]; !exists {
+		u.parent[target] = target
+		u.size[target] = 1
+	}
+}
+
+func (u *targetSets) find(target string) string {
+	root := target
+	for u.parent[root] != root {
+		root = u.parent[root]
+	}
+	// Path compression
+	for u.parent[target] != root {
+		target, u.parent[target] = u.parent[target], root
+	}
+	return root
+}
+
+func (u *targetSets) union(a, b string) {
+	ra, rb := u.find(a), u.find(b)
+	if ra == rb {
+		return
+	}
+	if u.size[ra] < u.size[rb] {
+		ra, rb = rb, ra
+	}
+	u.parent[rb] = ra
+	u.size[ra] += u.size[rb]
+}
+
+// Targets linked via shared queries
+type targetComponent struct {
+	targets  []string
+	queries  int      // Distinct queries mapped to the targets
+	bridging []string // Queries mapped to more than one target
+}
+
+// Find connected components of targets linked by multi-mapped queries
+func findComponents(reader RecordReader, opts Options) ([]targetComponent, error) {
+	sets := newTargetSets()
+	queryTargets := make(map[string][]string)
+	var queries []string // In input order
+
+	err := processRecords(reader, opts, func(record UCRecord) error {
+		// Unmatched queries are not linked to any target
+		if record.RecordType == "N" || record.Target == "*" {
+			return nil
+		}
+		sets.add(record.Target)
+
+		targets, exists := queryTargets[record.Query]
+		if !exists {
+			queries = append(queries, record.Query)
+		} else {
+			sets.union(targets[0], record.Target)
+		}
+		queryTargets[record.Query] = append(targets, record.Target)
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byRoot := make(map[string]*targetComponent)
+	for target := range sets.parent {
+		root := sets.find(target)
+		c, exists := byRoot[root]
+		if !exists {
+			c = &targetComponent{}
+			byRoot[root] = c
+		}
+		c.targets = append(c.targets, target)
+	}
+	for _, query := range queries {
+		targets := queryTargets[query]
+		c := byRoot[sets.find(targets[0])]
+		c.queries++
+		if len(targets) > 1 {
+			c.bridging = append(c.bridging, query)
+		}
+	}
+
+	components := make([]targetComponent, 0, len(byRoot))
+	for _, c := range byRoot {
+		slices.Sort(c.targets)
+		components = append(components, *c)
+	}
+
+	// Largest components first
+	slices.SortFunc(components, func(a, b targetComponent) int {
+		if len(a.targets) != len(b.targets) {
+			return len(b.targets) - len(a.targets)
+		}
+		return strings.Compare(a.targets[0], b.targets[0])
+	})
+	return components, nil
+}
+
+// Report connected components of targets sharing queries
+func runComponents(args []string) error {
+	opts := componentsOptions{}
+	fs := flag.NewFlagSet("components", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
+		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"all", "a", &opts.all, "Also report components with a single target", false},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs components -i <input.uc.gz> -o <components.tsv>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return err
+	}
+
+	input, err := openInputFile(opts.inputFile)
+	if err != nil {
+		return newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	ucOpts := Options{
+		inputOptions: opts.inputOptions,
+		inputFile:    opts.inputFile,
+		mapOnly:      true,
+		splitSeqID:   opts.splitSeqID,
+		removeDups:   true,
+	}
+	reader, err := openRecordReader(input, opts.inputFile, ucOpts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	components, err := findComponents(reader, ucOpts)
+	if err != nil {
+		return err
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := writeComponents(writer, components, opts.all); err != nil {
+		return newUCError("IO", "failed to write components", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return newUCError("IO", "failed to write components", err)
+	}
+
+	linked, linkedTargets, bridging, totalTargets := 0, 0, 0, 0
+	for _, c := range components {
+		totalTargets += len(c.targets)
+		if len(c.targets) > 1 {
+			linked++
+			linkedTargets += len(c.targets)
+			bridging += len(c.bridging)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ucs: %d of %d targets form %d linked components via %d bridging queries\n",
+		linkedTargets, totalTargets, linked, bridging)
+	return nil
+}
+
+// Write one line per component with its targets and bridging queries
+func writeComponents(w *bufio.Writer, components []targetComponent, all bool) error {
+	if _, err := w.WriteString("Component\tTargets\tQueries\tBridging\tTargetIDs\tBridgingQueries\n"); err != nil {
+		return err
+	}
+	for i, c := range components {
+		if len(c.targets) < 2 && !all {
+			continue
+		}
+		bridging := "*"
+		if len(c.bridging) > 0 {
+			bridging = strings.Join(c.bridging, ",")
+		}
+		_, err := fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s\n",
+			i+1, len(c.targets), c.queries, len(c.bridging), strings.Join(c.targets, ","), bridging)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/briandowns/spinner"
+)
+
+// Parse the field delimiter, accepting "\t" and "tab" for tabs
+func parseDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "", ",":
+		return ',', nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, newUCError("Argument", fmt.Sprintf("invalid delimiter %q", delimiter), nil)
+	}
+	return r, nil
+}
+
+// Process UC-file and write output as CSV (RFC 4180 quoting)
+func processAndWriteCSV(input *os.File, writer *bufio.Writer, opts Options, s *spinner.Spinner) error {
+	delimiter, err := parseDelimiter(opts.delimiter)
+	if err != nil {
+		return err
+	}
+	columns, err := parseColumns(opts.columns, opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := openRecordReader(input, opts.inputFile, opts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	w := csv.NewWriter(writer)
+	w.Comma = delimiter
+
+	// Header matches the text output of the same columns
+	if !opts.noHeader {
+		if err := w.Write(columnHeader(columns, opts)); err != nil {
+			return newUCError("IO", "failed to write header", err)
+		}
+	}
+
+	row := make([]string, len(columns))
+
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		for i, c := range columns {
+			row[i] = c.text(record)
+		}
+		return w.Write(row)
+	}, s)
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options of the diff command
+type diffOptions struct {
+	inputOptions
+	oldFile       string
+	newFile       string
+	outputFile    string
+	identityDelta float64
+	splitSeqID    bool
+}
+
+// Exit status of the diff command when an error occurs (1 means differences were found)
+const diffErrorStatus = 2
+
+// Exit status of a command, with an optional error reported before exiting
+type exitStatus struct {
+	code int
+	err  error
+}
+
+func (e *exitStatus) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitStatus) Unwrap() error {
+	return e.err
+}
+
+// Kinds of differences between two UC files
+const (
+	diffAdded      = "added"
+	diffRemoved    = "removed"
+	diffRecordType = "record_type"
+	diffTarget     = "target"
+	diffIdentity   = "identity"
+)
+
+// All hits of one query
+type queryHits struct {
+	recordType string
+	targets    []string            // Sorted; none for N records
+	identity   map[string]*float64 // Identity by target
+}
+
+// Records of a UC file, grouped by query
+type queryTable struct {
+	queries []string // In input order
+	hits    map[string]*queryHits
+}
+
+// Read all records of a UC file, grouped by query
+func loadQueryTable(fileName string, split bool, in inputOptions) (*queryTable, error) {
+	input, err := openInputFile(fileName)
+	if err != nil {
+		return nil, newUCError("IO", fmt.Sprintf("failed to open %s", fileName), err)
+	}
+	defer input.Close()
+
+	// N records are kept in any mode, so that changes of the record type are reported
+	opts := Options{
+		inputOptions: in,
+		inputFile:    fileName,
+		splitSeqID:   split,
+		removeDups:   true,
+		keepN:        true,
+	}
+	reader, err := openRecordReader(input, fileName, opts)
+	if err != nil {
+		return nil, newUCError("IO", fmt.Sprintf("failed to open %s", fileName), err)
+	}
+
+	t := &queryTable{hits: make(map[string]*queryHits)}
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		h, exists := t.hits[record.Query]
+		if !exists {
+			h = &queryHits{recordType: record.RecordType, identity: make(map[string]*float64)}
+			t.hits[record.Query] = h
+			t.queries = append(t.queries, record.Query)
+		}
+		if record.RecordType == "N" {
+			return nil
+		}
+		// A query with several records keeps the type of its first record
+		h.targets = append(h.targets, record.Target)
+		h.identity[record.Target] = record.Identity
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range t.hits {
+		slices.Sort(h.targets)
+	}
+	return t, nil
+}
+
+// One difference between two UC files
+type queryDiff struct {
+	change string
+	query  string
+	old    string
+	new    string
+}
+
+// Compare records of two UC files query by query
+func diffQueries(before, after *queryTable, identityDelta float64) []queryDiff {
+	var diffs []queryDiff
+	for _, query := range before.queries {
+		o := before.hits[query]
+		n, exists := after.hits[query]
+		if !exists {
+			diffs = append(diffs, queryDiff{diffRemoved, query, targetsText(o.targets), "*"})
+			continue
+		}
+
+		if o.recordType != n.recordType {
+			diffs = append(diffs, queryDiff{diffRecordType, query, o.recordType, n.recordType})
+		}
+		if !slices.Equal(o.targets, n.targets) {
+			diffs = append(diffs, queryDiff{diffTarget, query, targetsText(o.targets), targetsText(n.targets)})
+		}
+
+		// Identity changes of hits to the same target
+		for _, target := range o.targets {
+			oi, ni := o.identity[target], n.identity[target]
+			if oi == nil || ni == nil {
+				continue
+			}
+			if d := math.Abs(*ni - *oi); d > identityDelta {
+				diffs = append(diffs, queryDiff{diffIdentity, query,
+					target + ":" + ucIdentity(UCRecord{Identity: oi}),
+					target + ":" + ucIdentity(UCRecord{Identity: ni})})
+			}
+		}
+	}
+
+	for _, query := range after.queries {
+		if _, exists := before.hits[query]; !exists {
+			diffs = append(diffs, queryDiff{diffAdded, query, "*", targetsText(after.hits[query].targets)})
+		}
+	}
+	return diffs
+}
+
+// Comma-separated targets, or "*" for queries without hits
+func targetsText(targets []string) string {
+	if len(targets) == 0 {
+		return "*"
+	}
+	return strings.Join(targets, ",")
+}
+
+// Compare two UC files; exits with status 1 if they differ
+func runDiff(args []string) error {
+	opts := diffOptions{}
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"old", "a", &opts.oldFile, "Old input file, UC or Parquet", ""},
+		{"new", "b", &opts.newFile, "New input file, UC or Parquet", ""},
+		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"identity-delta", "d", &opts.identityDelta, "Report identity changes larger than this value", 0.0},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs diff -a <old.uc.gz> -b <new.uc.gz> -o <diff.tsv>
+
+Exit status is 0 if the files match, 1 if they differ, and 2 on errors.
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	diffs, err := diffFiles(opts)
+	if err != nil {
+		return &exitStatus{diffErrorStatus, err}
+	}
+	if len(diffs) > 0 {
+		return &exitStatus{code: 1}
+	}
+	return nil
+}
+
+// Compare the files and write the differences
+func diffFiles(opts diffOptions) ([]queryDiff, error) {
+	if opts.oldFile == "" || opts.newFile == "" {
+		return nil, newUCError("Argument", "both input files (--old and --new) are required", nil)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return nil, err
+	}
+
+	before, err := loadQueryTable(opts.oldFile, opts.splitSeqID, opts.inputOptions)
+	if err != nil {
+		return nil, err
+	}
+	after, err := loadQueryTable(opts.newFile, opts.splitSeqID, opts.inputOptions)
+	if err != nil {
+		return nil, err
+	}
+	diffs := diffQueries(before, after, opts.identityDelta)
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return nil, newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := writeDiffs(writer, diffs); err != nil {
+		return nil, newUCError("IO", "failed to write differences", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, newUCError("IO", "failed to write differences", err)
+	}
+
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.change]++
+	}
+	fmt.Fprintf(os.Stderr, "ucs: %d added, %d removed, %d changed target, %d changed identity, %d changed record type\n",
+		counts[diffAdded], counts[diffRemoved], counts[diffTarget], counts[diffIdentity], counts[diffRecordType])
+	return diffs, nil
+}
+
+func writeDiffs(w io.Writer, diffs []queryDiff) error {
+	if _, err := io.WriteString(w, "Change\tQuery\tOld\tNew\n"); err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.change, d.query, d.old, d.new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
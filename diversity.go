@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// Options shared by the diversity commands
+type diversityOptions struct {
+	inputOptions
+	inputFile     string
+	outputFile    string
+	sampleMapFile string
+	splitSeqID    bool
+	resolve       string
+	tieBreak      string
+	seed          int
+	depth         int
+	pooled        bool
+}
+
+// Flags shared by the diversity commands
+func diversityFlags(opts *diversityOptions) []flagPair {
+	return append([]flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
+		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"sample-map", "", &opts.sampleMapFile, "TSV of read ID (exact, prefix*, or /regex/) and sample (default: sample= annotations)", ""},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+		{"resolve", "", &opts.resolve, "Resolve queries with multiple hits, so that their reads are counted once: best, random, fractional, ambiguous", resolveBest},
+		{"tie-break", "", &opts.tieBreak, "Tie-break of best hits with equal identity: order, abundance", "order"},
+		{"seed", "", &opts.seed, "Random seed for --resolve random and rarefaction", 1},
+		{"depth", "", &opts.depth, "Rarefy to this number of reads per sample (default: 0, no rarefaction)", 0},
+		{"pooled", "", &opts.pooled, "Rarefy all samples together instead of each sample", false},
+	}, inputFlags(&opts.inputOptions)...)
+}
+
+// Reads of one query assigned to an OTU
+type readGroup struct {
+	sample string
+	target string
+	reads  float64
+}
+
+// OTU abundances per sample
+type sampleCounts struct {
+	samples []string                      // Sorted sample names
+	groups  []readGroup                   // In input order
+	counts  map[string]map[string]float64 // Sample -> target -> abundance
+}
+
+// Sum read groups into OTU abundances
+func (c *sampleCounts) count() {
+	c.counts = make(map[string]map[string]float64)
+	for _, g := range c.groups {
+		if _, exists := c.counts[g.sample]; !exists {
+			c.counts[g.sample] = make(map[string]float64)
+		}
+		c.counts[g.sample][g.target] += g.reads
+	}
+}
+
+// OTU abundances of one sample, in target order
+func (c *sampleCounts) abundances(sample string) []float64 {
+	targets := make([]string, 0, len(c.counts[sample]))
+	for target := range c.counts[sample] {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+
+	values := make([]float64, len(targets))
+	for i, target := range targets {
+		values[i] = c.counts[sample][target]
+	}
+	return values
+}
+
+// Count query abundances (from "size=" annotations) per sample and target
+func loadSampleCounts(opts diversityOptions) (*sampleCounts, error) {
+	ucOpts := Options{
+		inputOptions: opts.inputOptions,
+		inputFile:    opts.inputFile,
+		mapOnly:      opts.resolve == "", // Resolving hits needs identities
+		splitSeqID:   opts.splitSeqID,
+		removeDups:   true,
+		resolve:      opts.resolve,
+		tieBreak:     opts.tieBreak,
+		seed:         opts.seed,
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return nil, err
+	}
+	if err := checkResolve(ucOpts); err != nil {
+		return nil, err
+	}
+	if opts.depth > 0 && opts.resolve == resolveFractional {
+		return nil, newUCError("Argument", "rarefaction requires whole reads and cannot be combined with --resolve fractional", nil)
+	}
+	if opts.sampleMapFile != "" {
+		m, err := loadSampleMap(opts.sampleMapFile)
+		if err != nil {
+			return nil, err
+		}
+		ucOpts.sampleMap = m
+	}
+
+	input, err := openInputFile(opts.inputFile)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	reader, err := openRecordReader(input, opts.inputFile, ucOpts)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open input", err)
+	}
+
+	c := &sampleCounts{}
+	seen := make(map[string]struct{})
+	err = processRecords(reader, ucOpts, func(record UCRecord) error {
+		// Unmatched queries do not belong to any OTU
+		if record.RecordType == "N" {
+			return nil
+		}
+		sample, ok := recordSample(record)
+		if !ok {
+			sample = unassignedSample
+		}
+		if _, exists := seen[sample]; !exists {
+			seen[sample] = struct{}{}
+			c.samples = append(c.samples, sample)
+		}
+
+		abundance := queryAbundance(record)
+		if record.Weight != nil {
+			abundance = *record.Weight
+		}
+		c.groups = append(c.groups, readGroup{sample, record.Target, abundance})
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(c.samples)
+
+	if opts.depth > 0 {
+		if err := c.rarefy(opts.depth, opts.pooled, newRarefyRand(opts.seed)); err != nil {
+			return nil, err
+		}
+	}
+	c.count()
+	return c, nil
+}
+
+// Alpha-diversity estimates of one sample
+type alphaDiversity struct {
+	reads    float64
+	richness int
+	shannon  float64
+	simpson  float64 // Gini-Simpson index (1 - D)
+	chao1    float64 // Bias-corrected
+	ace      float64
+	coverage float64 // Good's coverage
+}
+
+// Abundance threshold of rare OTUs in the ACE estimator
+const aceRareThreshold = 10
+
+// Compute alpha-diversity estimates from OTU abundances
+func computeAlpha(abundances []float64) alphaDiversity {
+	var a alphaDiversity
+	for _, x := range abundances {
+		if x > 0 {
+			a.reads += x
+			a.richness++
+		}
+	}
+	if a.reads == 0 {
+		return a
+	}
+
+	// Frequency counts of singletons and doubletons
+	var f1, f2 float64
+	var sumP2 float64
+	for _, x := range abundances {
+		if x <= 0 {
+			continue
+		}
+		p := x / a.reads
+		a.shannon -= p * math.Log(p)
+		sumP2 += p * p
+		switch x {
+		case 1:
+			f1++
+		case 2:
+			f2++
+		}
+	}
+	a.simpson = 1 - sumP2
+	a.chao1 = float64(a.richness) + f1*(f1-1)/(2*(f2+1))
+	a.coverage = 1 - f1/a.reads
+	a.ace = aceEstimate(abundances, f1)
+	return a
+}
+
+// Abundance-based coverage estimator (Chao & Lee, 1992)
+func aceEstimate(abundances []float64, f1 float64) float64 {
+	var sRare, sAbund, nRare, sumFreq float64
+	for _, x := range abundances {
+		switch {
+		case x <= 0:
+		case x <= aceRareThreshold:
+			sRare++
+			nRare += x
+			sumFreq += x * (x - 1)
+		default:
+			sAbund++
+		}
+	}
+	if sRare == 0 {
+		return sAbund
+	}
+
+	// Undefined if all rare OTUs are singletons
+	cAce := 1 - f1/nRare
+	if cAce == 0 {
+		return math.NaN()
+	}
+	gamma2 := 0.0
+	if nRare > 1 {
+		gamma2 = math.Max(sRare/cAce*sumFreq/(nRare*(nRare-1))-1, 0)
+	}
+	return sAbund + sRare/cAce + f1/cAce*gamma2
+}
+
+// Compute alpha diversity per sample
+func runAlpha(args []string) error {
+	opts := diversityOptions{}
+	fs := flag.NewFlagSet("alpha", flag.ExitOnError)
+	flagPairs := diversityFlags(&opts)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs alpha -i <input.uc.gz> --sample-map <samples.tsv> -o <alpha.tsv>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	counts, err := loadSampleCounts(opts)
+	if err != nil {
+		return err
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := writeAlpha(writer, counts); err != nil {
+		return newUCError("IO", "failed to write alpha diversity", err)
+	}
+	return writer.Flush()
+}
+
+// Write one line of alpha-diversity estimates per sample
+func writeAlpha(w io.Writer, counts *sampleCounts) error {
+	if _, err := io.WriteString(w, "Sample\tReads\tRichness\tShannon\tSimpson\tChao1\tACE\tGoodsCoverage\n"); err != nil {
+		return err
+	}
+	for _, sample := range counts.samples {
+		a := computeAlpha(counts.abundances(sample))
+		_, err := fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			sample, formatCount(a.reads), a.richness,
+			formatMetric(a.shannon), formatMetric(a.simpson), formatMetric(a.chao1),
+			formatMetric(a.ace), formatMetric(a.coverage))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Abundance without trailing zeros (fractional with --resolve fractional)
+func formatCount(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// Diversity estimate with fixed precision; undefined values are written as "NA"
+func formatMetric(x float64) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return "NA"
+	}
+	return strconv.FormatFloat(x, 'f', 4, 64)
+}
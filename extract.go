@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Options of the extract command
+type extractOptions struct {
+	inputOptions
+	inputFile  string
+	seqFile    string
+	outputFile string
+	outputDir  string
+	targets    string
+	splitSeqID bool
+	maxOpen    int
+}
+
+// Extract cluster member sequences from FASTA/FASTQ using the UC mapping
+func runExtract(args []string) error {
+	opts := extractOptions{}
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
+		{"seqs", "q", &opts.seqFile, "Sequence file, FASTA or FASTQ (optionally gzipped)", ""},
+		{"output", "o", &opts.outputFile, "Output file with reads relabelled by centroid (default: stdout)", "-"},
+		{"outdir", "O", &opts.outputDir, "Output directory for per-cluster files", ""},
+		{"targets", "t", &opts.targets, "Comma-separated list of centroids to extract (default: all)", ""},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+		{"max-open", "", &opts.maxOpen, "Maximum number of simultaneously open per-cluster files", defaultMaxOpenFiles},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs extract -i <input.uc.gz> -q <reads.fastq.gz> -o <members.fastq>
+  ucs extract -i <input.uc.gz> -q <reads.fastq.gz> -O <output_dir>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	if opts.seqFile == "" {
+		return newUCError("Argument", "sequence file (--seqs) is required", nil)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return err
+	}
+
+	// Query to target mapping from the UC file
+	queryToTargets, err := loadQueryTargets(opts.inputFile, opts.splitSeqID, opts.targets, opts.inputOptions)
+	if err != nil {
+		return err
+	}
+
+	seqInput, err := openInputFile(opts.seqFile)
+	if err != nil {
+		return newUCError("IO", "failed to open sequence file", err)
+	}
+	defer seqInput.Close()
+
+	seqs, err := openSeqReader(seqInput, opts.seqFile)
+	if err != nil {
+		return newUCError("IO", "failed to read sequence file", err)
+	}
+
+	var extracted, missing int
+	if opts.outputDir != "" {
+		extracted, missing, err = extractPerCluster(seqs, queryToTargets, opts)
+	} else {
+		extracted, missing, err = extractRelabelled(seqs, queryToTargets, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "ucs: extracted %d sequences, %d sequences not found in UC\n", extracted, missing)
+	return nil
+}
+
+// Read the query to target(s) mapping, optionally limited to selected targets
+func loadQueryTargets(inputFile string, split bool, targets string, in inputOptions) (map[string][]string, error) {
+	input, err := openInputFile(inputFile)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	opts := Options{
+		inputOptions: in,
+		inputFile:    inputFile,
+		mapOnly:      true,
+		splitSeqID:   split,
+		removeDups:   true,
+	}
+	reader, err := openRecordReader(input, inputFile, opts)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open input", err)
+	}
+
+	var selected map[string]struct{}
+	if targets != "" {
+		selected = make(map[string]struct{})
+		for _, t := range strings.Split(targets, ",") {
+			selected[splitSeqID(strings.TrimSpace(t), split)] = struct{}{}
+		}
+	}
+
+	queryToTargets := make(map[string][]string)
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		if selected != nil {
+			if _, ok := selected[record.Target]; !ok {
+				return nil
+			}
+		}
+		queryToTargets[record.Query] = append(queryToTargets[record.Query], record.Target)
+		return nil
+	}, nil)
+	return queryToTargets, err
+}
+
+// Write all mapped reads into a single file, relabelled by their centroid
+func extractRelabelled(seqs *seqReader, queryToTargets map[string][]string, opts extractOptions) (int, int, error) {
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return 0, 0, newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+
+	return extractSeqs(seqs, queryToTargets, opts.splitSeqID, func(record seqRecord, target string) error {
+		// Centroid becomes the sequence ID, the original header is kept as description
+		return writeSeqRecord(writer, record, target+" "+record.header, seqs.fastq)
+	})
+}
+
+// Write reads of each cluster into a separate file named after the centroid
+func extractPerCluster(seqs *seqReader, queryToTargets map[string][]string, opts extractOptions) (int, int, error) {
+	if err := os.MkdirAll(opts.outputDir, 0o755); err != nil {
+		return 0, 0, newUCError("IO", "failed to create output directory", err)
+	}
+
+	ext := ".fasta"
+	if seqs.fastq {
+		ext = ".fastq"
+	}
+
+	// Centroids with the same safe file name get separate files
+	paths := newOutputPaths(opts.outputDir, ext)
+	pool := newOutputPool(opts.maxOpen)
+	extracted, missing, err := extractSeqs(seqs, queryToTargets, opts.splitSeqID, func(record seqRecord, target string) error {
+		w, _, err := pool.Writer(paths.Path(target))
+		if err != nil {
+			return err
+		}
+		return writeSeqRecord(w, record, record.header, seqs.fastq)
+	})
+	if closeErr := pool.Close(); err == nil && closeErr != nil {
+		err = newUCError("IO", "failed to close output files", closeErr)
+	}
+	return extracted, missing, err
+}
+
+// Stream the sequence file once, passing each mapped read to the handler
+func extractSeqs(seqs *seqReader, queryToTargets map[string][]string, split bool, handler func(seqRecord, string) error) (int, int, error) {
+	extracted, missing := 0, 0
+	for {
+		record, err := seqs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, missing, newUCError("IO", "failed to read sequence file", err)
+		}
+
+		targets, ok := queryToTargets[splitSeqID(record.ID(), split)]
+		if !ok {
+			missing++
+			continue
+		}
+		for _, target := range targets {
+			if err := handler(record, target); err != nil {
+				return extracted, missing, newUCError("IO", fmt.Sprintf("failed to write sequence %s", record.ID()), err)
+			}
+		}
+		extracted++
+	}
+	return extracted, missing, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Options of the graph command
+type graphOptions struct {
+	inputOptions
+	inputFile          string
+	outputFile         string
+	format             string
+	bipartite          bool
+	collapseSingletons bool
+	splitSeqID         bool
+}
+
+// Node kinds
+const (
+	nodeCentroid  = "centroid"
+	nodeMember    = "member"
+	nodeUnmatched = "unmatched"
+	nodeCluster   = "cluster" // Centroid partition of a bipartite graph
+)
+
+type graphNode struct {
+	id    string
+	label string
+	kind  string
+}
+
+type graphEdge struct {
+	source string
+	target string
+	weight float64 // Identity with the centroid
+}
+
+// Query-centroid graph of a UC file
+type clusterGraph struct {
+	nodes []graphNode
+	index map[string]int
+	edges []graphEdge
+}
+
+func (g *clusterGraph) addNode(id, label, kind string) {
+	if i, exists := g.index[id]; exists {
+		// Centroids may first appear as hit targets
+		if kind == nodeCentroid {
+			g.nodes[i].kind = kind
+		}
+		return
+	}
+	g.index[id] = len(g.nodes)
+	g.nodes = append(g.nodes, graphNode{id: id, label: label, kind: kind})
+}
+
+// Build the graph from query/target pairs of the UC records
+func buildClusterGraph(reader RecordReader, opts Options, bipartite bool) (*clusterGraph, error) {
+	g := &clusterGraph{index: make(map[string]int)}
+
+	// Bipartite graphs keep sequences and clusters in separate partitions
+	seqID := func(id string) string { return id }
+	clusterID := func(id string) string { return id }
+	if bipartite {
+		seqID = func(id string) string { return "seq:" + id }
+		clusterID = func(id string) string { return "cluster:" + id }
+	}
+
+	err := processRecords(reader, opts, func(record UCRecord) error {
+		switch record.RecordType {
+		case "S":
+			if bipartite {
+				g.addNode(seqID(record.Query), record.Query, nodeCentroid)
+				g.addNode(clusterID(record.Query), record.Query, nodeCluster)
+				g.edges = append(g.edges, graphEdge{seqID(record.Query), clusterID(record.Query), 100})
+			} else {
+				g.addNode(record.Query, record.Query, nodeCentroid)
+			}
+		case "H":
+			weight := 0.0
+			if record.Identity != nil {
+				weight = *record.Identity
+			}
+			g.addNode(seqID(record.Query), record.Query, nodeMember)
+			if bipartite {
+				g.addNode(clusterID(record.Target), record.Target, nodeCluster)
+			} else {
+				g.addNode(record.Target, record.Target, nodeCentroid)
+			}
+			g.edges = append(g.edges, graphEdge{seqID(record.Query), clusterID(record.Target), weight})
+		case "N":
+			g.addNode(seqID(record.Query), record.Query, nodeUnmatched)
+		}
+		return nil
+	}, nil)
+	return g, err
+}
+
+// Remove singleton clusters (centroids without members) and unmatched queries
+func (g *clusterGraph) collapseSingletons(bipartite bool) {
+	degree := make(map[string]int)
+	for _, e := range g.edges {
+		degree[e.source]++
+		degree[e.target]++
+	}
+
+	// In bipartite graphs the centroid-cluster edge does not count as membership
+	minDegree := 1
+	if bipartite {
+		minDegree = 2
+	}
+
+	remove := make(map[string]struct{})
+	for _, n := range g.nodes {
+		switch n.kind {
+		case nodeUnmatched:
+			remove[n.id] = struct{}{}
+		case nodeCentroid, nodeCluster:
+			if degree[n.id] < minDegree {
+				remove[n.id] = struct{}{}
+			}
+		}
+	}
+	if bipartite {
+		// Drop the centroid of a singleton cluster together with the cluster node
+		for _, e := range g.edges {
+			if _, ok := remove[e.target]; ok {
+				remove[e.source] = struct{}{}
+			}
+		}
+	}
+
+	nodes := g.nodes[:0]
+	g.index = make(map[string]int)
+	for _, n := range g.nodes {
+		if _, ok := remove[n.id]; !ok {
+			g.index[n.id] = len(nodes)
+			nodes = append(nodes, n)
+		}
+	}
+	g.nodes = nodes
+
+	edges := g.edges[:0]
+	for _, e := range g.edges {
+		_, rs := remove[e.source]
+		_, rt := remove[e.target]
+		if !rs && !rt {
+			edges = append(edges, e)
+		}
+	}
+	g.edges = edges
+}
+
+// Export the query-centroid graph of a UC file
+func runGraph(args []string) error {
+	opts := graphOptions{}
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
+		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"format", "f", &opts.format, "Graph format: graphml, gexf, dot, edgelist (default: from output file extension)", ""},
+		{"bipartite", "b", &opts.bipartite, "Build a bipartite sequence-cluster graph", false},
+		{"collapse-singletons", "c", &opts.collapseSingletons, "Omit singleton clusters and unmatched queries", false},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs graph -i <input.uc.gz> -o <graph.graphml>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	format := opts.format
+	if format == "" {
+		format = graphFormat(opts.outputFile)
+	}
+	write, ok := graphWriters[format]
+	if !ok {
+		return newUCError("Argument", fmt.Sprintf("unknown graph format %q", format), nil)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return err
+	}
+
+	input, err := openInputFile(opts.inputFile)
+	if err != nil {
+		return newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	ucOpts := Options{
+		inputOptions: opts.inputOptions,
+		inputFile:    opts.inputFile,
+		splitSeqID:   opts.splitSeqID,
+		removeDups:   true,
+	}
+	reader, err := openRecordReader(input, opts.inputFile, ucOpts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	g, err := buildClusterGraph(reader, ucOpts, opts.bipartite)
+	if err != nil {
+		return err
+	}
+	if opts.collapseSingletons {
+		g.collapseSingletons(opts.bipartite)
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := write(writer, g); err != nil {
+		return newUCError("IO", "failed to write graph", err)
+	}
+	return writer.Flush()
+}
+
+// Graph format from the output file extension
+func graphFormat(fileName string) string {
+	switch {
+	case strings.HasSuffix(fileName, ".graphml"):
+		return "graphml"
+	case strings.HasSuffix(fileName, ".gexf"):
+		return "gexf"
+	case strings.HasSuffix(fileName, ".dot"), strings.HasSuffix(fileName, ".gv"):
+		return "dot"
+	}
+	return "edgelist"
+}
+
+// Graph writers by format
+var graphWriters = map[string]func(io.Writer, *clusterGraph) error{
+	"graphml":  writeGraphML,
+	"gexf":     writeGEXF,
+	"dot":      writeDOT,
+	"edgelist": writeEdgeList,
+}
+
+// Escape text for XML attributes
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+func formatWeight(w float64) string {
+	return strconv.FormatFloat(w, 'f', -1, 64)
+}
+
+// Weighted edge list (TSV)
+func writeEdgeList(w io.Writer, g *clusterGraph) error {
+	if _, err := io.WriteString(w, "Source\tTarget\tWeight\n"); err != nil {
+		return err
+	}
+	for _, e := range g.edges {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", e.source, e.target, formatWeight(e.weight)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Graphviz DOT (undirected)
+func writeDOT(w io.Writer, g *clusterGraph) error {
+	quote := strconv.Quote
+	if _, err := io.WriteString(w, "graph ucs {\n"); err != nil {
+		return err
+	}
+	for _, n := range g.nodes {
+		if _, err := fmt.Fprintf(w, "  %s [label=%s, type=%s];\n", quote(n.id), quote(n.label), quote(n.kind)); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.edges {
+		if _, err := fmt.Fprintf(w, "  %s -- %s [weight=%s];\n", quote(e.source), quote(e.target), formatWeight(e.weight)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// GraphML with node type and label and edge weight attributes
+func writeGraphML(w io.Writer, g *clusterGraph) error {
+	header := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="label" for="node" attr.name="label" attr.type="string"/>
+  <key id="type" for="node" attr.name="type" attr.type="string"/>
+  <key id="weight" for="edge" attr.name="weight" attr.type="double"/>
+  <graph id="ucs" edgedefault="undirected">
+`
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for _, n := range g.nodes {
+		_, err := fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"label\">%s</data><data key=\"type\">%s</data></node>\n",
+			xmlEscape(n.id), xmlEscape(n.label), n.kind)
+		if err != nil {
+			return err
+		}
+	}
+	for i, e := range g.edges {
+		_, err := fmt.Fprintf(w, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\"><data key=\"weight\">%s</data></edge>\n",
+			i, xmlEscape(e.source), xmlEscape(e.target), formatWeight(e.weight))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "  </graph>\n</graphml>\n")
+	return err
+}
+
+// GEXF 1.3 with node type attribute and edge weights
+func writeGEXF(w io.Writer, g *clusterGraph) error {
+	header := `<?xml version="1.0" encoding="UTF-8"?>
+<gexf xmlns="http://gexf.net/1.3" version="1.3">
+  <graph defaultedgetype="undirected">
+    <attributes class="node">
+      <attribute id="type" title="type" type="string"/>
+    </attributes>
+    <nodes>
+`
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for _, n := range g.nodes {
+		_, err := fmt.Fprintf(w, "      <node id=\"%s\" label=\"%s\"><attvalues><attvalue for=\"type\" value=\"%s\"/></attvalues></node>\n",
+			xmlEscape(n.id), xmlEscape(n.label), n.kind)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "    </nodes>\n    <edges>\n"); err != nil {
+		return err
+	}
+	for i, e := range g.edges {
+		_, err := fmt.Fprintf(w, "      <edge id=\"%d\" source=\"%s\" target=\"%s\" weight=\"%s\"/>\n",
+			i, xmlEscape(e.source), xmlEscape(e.target), formatWeight(e.weight))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "    </edges>\n  </graph>\n</gexf>\n")
+	return err
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Options of the identity command
+type identityOptions struct {
+	inputOptions
+	inputFile     string
+	outputFile    string
+	histogramFile string
+	format        string
+	binWidth      float64
+	lowIdentity   float64
+	splitSeqID    bool
+}
+
+// Number of standard deviations below the mean of all clusters that flags a cluster
+const lowIdentityZScore = 2
+
+// Identity of cluster members to the centroid
+type clusterIdentity struct {
+	Cluster  uint32   `json:"cluster"`
+	Centroid string   `json:"centroid"`
+	Members  int      `json:"members"` // Number of hits
+	Mean     *float64 `json:"mean_identity"`
+	Min      *float64 `json:"min_identity"`
+	Max      *float64 `json:"max_identity"`
+	Low      bool     `json:"low_identity"`
+
+	sum float64
+}
+
+// Histogram bin of hit identities; the last bin includes its upper bound
+type identityBin struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+// Identity statistics of all clusters
+type identityReport struct {
+	Clusters  []*clusterIdentity `json:"clusters"`
+	Histogram []identityBin      `json:"histogram"`
+	Threshold float64            `json:"low_identity_threshold"`
+}
+
+// Collect hit identities per cluster and over all hits
+func identityStats(reader RecordReader, opts Options, binWidth, lowIdentity float64) (*identityReport, error) {
+	clusters := make(map[uint32]*clusterIdentity)
+	var identities []float64
+
+	err := processRecords(reader, opts, func(record UCRecord) error {
+		switch record.RecordType {
+		case "S", "H":
+		default:
+			return nil
+		}
+
+		c, exists := clusters[record.ClusterNumber]
+		if !exists {
+			c = &clusterIdentity{Cluster: record.ClusterNumber}
+			clusters[record.ClusterNumber] = c
+		}
+		if record.RecordType == "S" {
+			c.Centroid = record.Query
+			return nil
+		}
+		if c.Centroid == "" {
+			c.Centroid = record.Target
+		}
+		if record.Identity == nil {
+			return nil
+		}
+
+		identity := *record.Identity
+		identities = append(identities, identity)
+		c.Members++
+		c.sum += identity
+		if c.Min == nil || identity < *c.Min {
+			c.Min = &identity
+		}
+		if c.Max == nil || identity > *c.Max {
+			c.Max = &identity
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &identityReport{Histogram: identityHistogram(identities, binWidth)}
+	for _, c := range clusters {
+		if c.Members > 0 {
+			mean := c.sum / float64(c.Members)
+			c.Mean = &mean
+		}
+		report.Clusters = append(report.Clusters, c)
+	}
+	slices.SortFunc(report.Clusters, func(a, b *clusterIdentity) int {
+		return int(a.Cluster) - int(b.Cluster)
+	})
+
+	// Without a fixed threshold, flag clusters far below the mean of all clusters
+	report.Threshold = lowIdentity
+	if lowIdentity <= 0 {
+		report.Threshold = autoLowIdentity(report.Clusters)
+	}
+	for _, c := range report.Clusters {
+		c.Low = c.Mean != nil && *c.Mean < report.Threshold
+	}
+	return report, nil
+}
+
+// Mean identity of clusters minus lowIdentityZScore standard deviations
+func autoLowIdentity(clusters []*clusterIdentity) float64 {
+	var n, sum, sumSq float64
+	for _, c := range clusters {
+		if c.Mean != nil {
+			n++
+			sum += *c.Mean
+			sumSq += *c.Mean * *c.Mean
+		}
+	}
+	if n < 2 {
+		return 0
+	}
+	mean := sum / n
+	sd := math.Sqrt(math.Max(sumSq/n-mean*mean, 0) * n / (n - 1))
+	return mean - lowIdentityZScore*sd
+}
+
+// Histogram of identities in bins of equal width, up to 100%
+func identityHistogram(identities []float64, width float64) []identityBin {
+	if len(identities) == 0 {
+		return nil
+	}
+	lower := math.Floor(slices.Min(identities)/width) * width
+	upper := math.Max(100, slices.Max(identities))
+
+	bins := make([]identityBin, max(int(math.Ceil((upper-lower)/width)), 1))
+	for i := range bins {
+		bins[i].Lower = lower + float64(i)*width
+		bins[i].Upper = math.Min(lower+float64(i+1)*width, upper)
+	}
+	for _, identity := range identities {
+		i := min(int((identity-lower)/width), len(bins)-1)
+		bins[i].Count++
+	}
+	return bins
+}
+
+// Summarise identities of hits to their centroids
+func runIdentity(args []string) error {
+	opts := identityOptions{}
+	fs := flag.NewFlagSet("identity", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
+		{"output", "o", &opts.outputFile, "Output file with per-cluster statistics (default: stdout)", "-"},
+		{"histogram", "H", &opts.histogramFile, "Output file for the identity histogram (TSV format)", ""},
+		{"format", "f", &opts.format, "Output format: tsv, json (default: from output file extension)", ""},
+		{"bin-width", "b", &opts.binWidth, "Width of histogram bins, in percent identity", 1.0},
+		{"low-identity", "l", &opts.lowIdentity, "Flag clusters with mean identity below this value (default: 2 SD below the mean of all clusters)", 0.0},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs identity -i <input.uc.gz> -o <clusters.tsv> -H <histogram.tsv>
+  ucs identity -i <input.uc.gz> -o <identity.json>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	if opts.binWidth <= 0 {
+		return newUCError("Argument", "histogram bin width must be positive", nil)
+	}
+	format := opts.format
+	if format == "" {
+		format = "tsv"
+		if strings.HasSuffix(opts.outputFile, ".json") {
+			format = "json"
+		}
+	}
+	if format != "tsv" && format != "json" {
+		return newUCError("Argument", fmt.Sprintf("unknown identity report format %q", format), nil)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return err
+	}
+
+	input, err := openInputFile(opts.inputFile)
+	if err != nil {
+		return newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	ucOpts := Options{
+		inputOptions: opts.inputOptions,
+		inputFile:    opts.inputFile,
+		splitSeqID:   opts.splitSeqID,
+		removeDups:   true,
+	}
+	reader, err := openRecordReader(input, opts.inputFile, ucOpts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	report, err := identityStats(reader, ucOpts, opts.binWidth, opts.lowIdentity)
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(opts.outputFile, func(w io.Writer) error {
+		if format == "json" {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		}
+		return writeClusterIdentities(w, report.Clusters)
+	}); err != nil {
+		return err
+	}
+
+	if opts.histogramFile != "" {
+		return writeReport(opts.histogramFile, func(w io.Writer) error {
+			return writeIdentityHistogram(w, report.Histogram)
+		})
+	}
+	return nil
+}
+
+// Write a report into a buffered output file
+func writeReport(fileName string, write func(io.Writer) error) error {
+	output, err := createOutputFile(fileName)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := write(writer); err != nil {
+		return newUCError("IO", fmt.Sprintf("failed to write %s", fileName), err)
+	}
+	return writer.Flush()
+}
+
+// One line per cluster; clusters without hits have no identities ("*")
+func writeClusterIdentities(w io.Writer, clusters []*clusterIdentity) error {
+	if _, err := io.WriteString(w, "Cluster\tCentroid\tMembers\tMeanIdentity\tMinIdentity\tMaxIdentity\tLowIdentity\n"); err != nil {
+		return err
+	}
+	for _, c := range clusters {
+		_, err := fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%t\n",
+			c.Cluster, c.Centroid, c.Members,
+			identityText(c.Mean), identityText(c.Min), identityText(c.Max), c.Low)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func identityText(x *float64) string {
+	if x == nil {
+		return "*"
+	}
+	return fmt.Sprintf("%.2f", *x)
+}
+
+func writeIdentityHistogram(w io.Writer, bins []identityBin) error {
+	if _, err := io.WriteString(w, "Lower\tUpper\tCount\n"); err != nil {
+		return err
+	}
+	for _, b := range bins {
+		if _, err := fmt.Fprintf(w, "%g\t%g\t%d\n", b.Lower, b.Upper, b.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/briandowns/spinner"
+)
+
+// Process UC-file and write output as JSON Lines (one object per record)
+func processAndWriteJSON(input *os.File, writer *bufio.Writer, opts Options, s *spinner.Spinner) error {
+	// JSON fields default to the selected output columns
+	fields := opts.jsonFields
+	if fields == "" {
+		fields = opts.columns
+	}
+	columns, err := parseColumns(fields, opts)
+	if err != nil {
+		return err
+	}
+
+	// Explicitly selected fields may need more than Query and Target
+	if fields != "" {
+		opts.mapOnly = false
+	}
+
+	reader, err := openRecordReader(input, opts.inputFile, opts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	// Field names are encoded once and reused for every record
+	keys := make([][]byte, len(columns))
+	for i, c := range columns {
+		key, err := json.Marshal(c.name)
+		if err != nil {
+			return newUCError("IO", "failed to encode field name", err)
+		}
+		keys[i] = key
+	}
+
+	var line []byte
+	return processRecords(reader, opts, func(record UCRecord) error {
+		line = line[:0]
+		line = append(line, '{')
+		for i, c := range columns {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			value, err := json.Marshal(c.value(record))
+			if err != nil {
+				return err
+			}
+			line = append(line, keys[i]...)
+			line = append(line, ':')
+			line = append(line, value...)
+		}
+		line = append(line, '}', '\n')
+
+		_, err := writer.Write(line)
+		return err
+	}, s)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options of the merge command
+type mergeOptions struct {
+	inputOptions
+	outputFile string
+	format     string
+	columns    string
+	splitSeqID bool
+}
+
+// Renumbering state shared by all merged inputs
+type ucMerger struct {
+	numbers    map[string]uint32   // Centroid -> new cluster number
+	parent     map[string]string   // Centroid -> centroid it was merged into (itself if kept)
+	labels     map[string]string   // Centroid -> label with annotations
+	seeded     map[string]struct{} // Centroids with an S record written
+	seen       map[string]struct{} // Query-target pairs already written
+	duplicates int                 // S records of centroids seen in an earlier input
+	demoted    int                 // Centroids that are members of another centroid in some input
+}
+
+func newUCMerger() *ucMerger {
+	return &ucMerger{
+		numbers: make(map[string]uint32),
+		parent:  make(map[string]string),
+		labels:  make(map[string]string),
+		seeded:  make(map[string]struct{}),
+		seen:    make(map[string]struct{}),
+	}
+}
+
+// Cluster number of a centroid, assigned in order of first appearance
+func (m *ucMerger) clusterNumber(centroid string) uint32 {
+	number, exists := m.numbers[centroid]
+	if !exists {
+		number = uint32(len(m.numbers))
+		m.numbers[centroid] = number
+	}
+	return number
+}
+
+// Final centroid of a sequence (itself unless it is a merged centroid)
+func (m *ucMerger) centroid(id string) string {
+	for {
+		parent, ok := m.parent[id]
+		if !ok || parent == id {
+			return id
+		}
+		id = parent
+	}
+}
+
+// Open an input with the options of the merge
+func openMergeInput(fileName string, opts Options) (*os.File, RecordReader, error) {
+	input, err := openInputFile(fileName)
+	if err != nil {
+		return nil, nil, newUCError("IO", fmt.Sprintf("failed to open %s", fileName), err)
+	}
+	reader, err := openRecordReader(input, fileName, opts)
+	if err != nil {
+		input.Close()
+		return nil, nil, newUCError("IO", fmt.Sprintf("failed to open %s", fileName), err)
+	}
+	return input, reader, nil
+}
+
+// First pass: find the roles of all sequences. A centroid of one input that is
+// a member of another centroid in a different input is merged into that cluster.
+func (m *ucMerger) scan(inputs []string, opts Options) error {
+	opts.quiet = true
+	var order []string                  // Centroids, in order of first appearance
+	memberOf := make(map[string]string) // Sequence -> target of its first hit
+	for _, fileName := range inputs {
+		input, reader, err := openMergeInput(fileName, opts)
+		if err != nil {
+			return err
+		}
+		err = processRecords(reader, opts, func(record UCRecord) error {
+			switch record.RecordType {
+			case "S":
+				if _, exists := m.parent[record.Query]; !exists {
+					m.parent[record.Query] = record.Query
+					m.labels[record.Query] = record.QueryLabel
+					order = append(order, record.Query)
+				}
+			case "H":
+				if _, exists := memberOf[record.Query]; !exists {
+					memberOf[record.Query] = record.Target
+				}
+			}
+			return nil
+		}, nil)
+		input.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Merges that would form a cycle keep the later centroid
+	for _, c := range order {
+		target, ok := memberOf[c]
+		if !ok {
+			continue
+		}
+		if _, isCentroid := m.parent[target]; !isCentroid || m.centroid(target) == c {
+			continue
+		}
+		m.parent[c] = target
+		m.demoted++
+	}
+	return nil
+}
+
+// Second pass: renumber records of one input and pass them to the handler
+func (m *ucMerger) add(fileName string, opts Options, handler func(UCRecord) error) error {
+	input, reader, err := openMergeInput(fileName, opts)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	return processRecords(reader, opts, func(record UCRecord) error {
+		switch record.RecordType {
+		case "S":
+			// Merged centroids are written as the hit to their new centroid
+			if m.centroid(record.Query) != record.Query {
+				return nil
+			}
+		case "H":
+			// Members of merged centroids are re-pointed to the new centroid
+			if centroid := m.centroid(record.Target); centroid != record.Target {
+				record.Target, record.TargetLabel = centroid, m.labels[centroid]
+			}
+			if record.Query == record.Target {
+				return nil
+			}
+		}
+
+		// Records repeated across inputs are written once
+		pairKey := record.Query + "\t" + record.Target
+		if _, exists := m.seen[pairKey]; exists {
+			if record.RecordType == "S" {
+				m.duplicates++
+			}
+			return nil
+		}
+		m.seen[pairKey] = struct{}{}
+
+		switch record.RecordType {
+		case "S":
+			// A centroid present in several inputs keeps a single cluster
+			m.seeded[record.Query] = struct{}{}
+			record.ClusterNumber = m.clusterNumber(record.Query)
+		case "H":
+			record.ClusterNumber = m.clusterNumber(record.Target)
+		}
+		return handler(record)
+	}, nil)
+}
+
+// Merge UC files from chunked runs into a single file with consistent cluster numbers
+func runMerge(args []string) error {
+	opts := mergeOptions{}
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+
+	flagPairs := []flagPair{
+		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"format", "f", &opts.format, "Output format: uc, tsv (default: from output file extension, uc for stdout)", ""},
+		{"columns", "c", &opts.columns, "Comma-separated list of columns of the tsv output", ""},
+		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
+	}
+	flagPairs = append(flagPairs, inputFlags(&opts.inputOptions)...)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs merge -o <merged.uc> <chunk1.uc.gz> <chunk2.uc.gz> ...
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		return newUCError("Argument", "no input files given", nil)
+	}
+	if slices.Contains(inputs, "-") {
+		return newUCError("Argument", "merge reads its inputs twice and cannot read stdin", nil)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		return err
+	}
+
+	// Output is UC for stdout, otherwise the format follows the file extension
+	// (e.g., merged.uc.gz is gzipped UC)
+	compressed := strings.HasSuffix(opts.outputFile, ".gz")
+	format := opts.format
+	if format == "" {
+		format = "uc"
+		if opts.outputFile != "-" {
+			format = outputFormat(Options{outputFile: strings.TrimSuffix(opts.outputFile, ".gz")})
+		}
+	}
+
+	// Full records are needed for cluster numbers
+	ucOpts := Options{
+		inputOptions: opts.inputOptions,
+		columns:      opts.columns,
+		splitSeqID:   opts.splitSeqID,
+		removeDups:   true,
+	}
+
+	var write func(*bufio.Writer, UCRecord) error
+	var header string
+	switch format {
+	case "uc":
+		write = writeUCLine
+	case "tsv":
+		var columns []ucColumn
+		var err error
+		header, columns, err = textHeader(ucOpts)
+		if err != nil {
+			return err
+		}
+		write = func(w *bufio.Writer, record UCRecord) error {
+			return writeUCRecord(w, record, ucOpts, columns)
+		}
+	default:
+		return newUCError("Argument", fmt.Sprintf("merge output supports uc and tsv formats, not %s", format), nil)
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	var w io.Writer = output
+	if compressed {
+		gz := gzip.NewWriter(output)
+		defer gz.Close()
+		w = gz
+	}
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	if _, err := writer.WriteString(header); err != nil {
+		return newUCError("IO", "failed to write header", err)
+	}
+
+	merger := newUCMerger()
+	if err := merger.scan(inputs, ucOpts); err != nil {
+		return err
+	}
+	clusters := make(map[uint32]*ucCluster)
+	for _, fileName := range inputs {
+		ucOpts.inputFile = fileName
+		err := merger.add(fileName, ucOpts, func(record UCRecord) error {
+			trackCluster(clusters, record)
+			return write(writer, record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if format == "uc" {
+		if err := writeCRecords(writer, clusters); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "ucs: merged %d files into %d clusters (%d centroids found in several files, %d merged into other clusters)\n",
+		len(inputs), len(clusters), merger.duplicates, merger.demoted)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// Modes of the program that wrote the UC file (--mode)
+const (
+	modeAuto    = "auto"
+	modeSearch  = "search"  // --usearch_global, --search_exact: N records are unmatched queries
+	modeCluster = "cluster" // --cluster_fast, --cluster_size, ...
+	modeDerep   = "derep"   // --derep_fulllength, --derep_prefix: same layout as clusterings
+)
+
+// Check the UC mode option
+func checkMode(mode string) error {
+	switch mode {
+	case "", modeAuto, modeSearch, modeCluster, modeDerep:
+		return nil
+	}
+	return newUCError("Argument", fmt.Sprintf("unknown UC mode %q", mode), nil)
+}
+
+// Mode set by the user, or "" if it is detected from the records:
+// clusterings contain S records, which never appear in searches
+func fixedMode(mode string) string {
+	switch mode {
+	case modeAuto:
+		return ""
+	case modeDerep:
+		return modeCluster
+	}
+	return mode
+}
+
+// Whether N records are unmatched queries, given whether any S record was seen
+func isSearch(mode string, clustered bool) bool {
+	if mode = fixedMode(mode); mode != "" {
+		return mode == modeSearch
+	}
+	return !clustered
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Default cap on concurrently open output files
+const defaultMaxOpenFiles = 256
+
+// Output file kept open by the pool
+type pooledFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	lastUse int
+}
+
+// Pool of output files with a cap on concurrently open handles.
+// Least recently used files are closed and later reopened in append mode.
+type outputPool struct {
+	maxOpen int
+	open    map[string]*pooledFile
+	created map[string]struct{}
+	paths   []string // Created files, in creation order
+	clock   int
+}
+
+func newOutputPool(maxOpen int) *outputPool {
+	if maxOpen < 1 {
+		maxOpen = defaultMaxOpenFiles
+	}
+	return &outputPool{
+		maxOpen: maxOpen,
+		open:    make(map[string]*pooledFile),
+		created: make(map[string]struct{}),
+	}
+}
+
+// Writer returns a buffered writer for the file, opening it if needed.
+// The flag reports whether the file was newly created.
+func (p *outputPool) Writer(path string) (*bufio.Writer, bool, error) {
+	p.clock++
+	if f, ok := p.open[path]; ok {
+		f.lastUse = p.clock
+		return f.writer, false, nil
+	}
+
+	if len(p.open) >= p.maxOpen {
+		if err := p.evict(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Files are truncated when first created and appended to afterwards
+	_, exists := p.created[path]
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !exists {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		p.created[path] = struct{}{}
+		p.paths = append(p.paths, path)
+	}
+
+	f := &pooledFile{file: file, writer: bufio.NewWriter(file), lastUse: p.clock}
+	p.open[path] = f
+	return f.writer, !exists, nil
+}
+
+// Close the least recently used file
+func (p *outputPool) evict() error {
+	oldest := ""
+	for path, f := range p.open {
+		if oldest == "" || f.lastUse < p.open[oldest].lastUse {
+			oldest = path
+		}
+	}
+	return p.closeFile(oldest)
+}
+
+func (p *outputPool) closeFile(path string) error {
+	f := p.open[path]
+	delete(p.open, path)
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// Close all open files
+func (p *outputPool) Close() error {
+	var firstErr error
+	for path := range p.open {
+		if err := p.closeFile(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Paths of all files written, in creation order
+func (p *outputPool) Paths() []string {
+	return p.paths
+}
+
+// Parquet output file of the pool
+type pooledParquet struct {
+	writer *parquet.GenericWriter[any] // Created with the first batch
+	rows   []parquet.Row               // Rows not yet written
+}
+
+// Pool of Parquet output files. Rows are buffered per file and written in batches,
+// each batch as a row group. Parquet files cannot be appended to once closed,
+// so the writers stay open until the end and write through an outputPool,
+// which caps the number of open file handles instead.
+type parquetPool struct {
+	files       *outputPool
+	options     []parquet.WriterOption // Shared, so that writers reuse the codec's encoders
+	toRow       func(parquet.Row, UCRecord) parquet.Row
+	batchSize   int
+	maxBuffered int // Rows buffered over all files before all batches are written
+	buffered    int
+	outputs     map[string]*pooledParquet
+	paths       []string // In creation order
+}
+
+func newParquetPool(maxOpen, batchSize int, schema *parquet.Schema, toRow func(parquet.Row, UCRecord) parquet.Row) *parquetPool {
+	return &parquetPool{
+		files:       newOutputPool(maxOpen),
+		options:     append(parquetWriterOptions(), schema),
+		toRow:       toRow,
+		batchSize:   batchSize,
+		maxBuffered: 16 * batchSize,
+		outputs:     make(map[string]*pooledParquet),
+	}
+}
+
+// Buffer a record of the file, writing the file's batch once it is full
+func (p *parquetPool) Write(path string, record UCRecord) error {
+	f, ok := p.outputs[path]
+	if !ok {
+		f = &pooledParquet{}
+		p.outputs[path] = f
+		p.paths = append(p.paths, path)
+	}
+
+	// Row buffers are reused between batches
+	n := len(f.rows)
+	if n < cap(f.rows) {
+		f.rows = f.rows[:n+1]
+	} else {
+		f.rows = append(f.rows, nil)
+	}
+	f.rows[n] = p.toRow(f.rows[n], record)
+	p.buffered++
+
+	if len(f.rows) >= p.batchSize {
+		return p.flush(path, f)
+	}
+	if p.buffered >= p.maxBuffered {
+		return p.flushAll()
+	}
+	return nil
+}
+
+// Write the buffered rows of a file as a row group
+func (p *parquetPool) flush(path string, f *pooledParquet) error {
+	if len(f.rows) == 0 {
+		return nil
+	}
+	if f.writer == nil {
+		f.writer = parquet.NewGenericWriter[any](&pooledWriter{p.files, path}, p.options...)
+	}
+	if _, err := f.writer.WriteRows(f.rows); err != nil {
+		return err
+	}
+	p.buffered -= len(f.rows)
+	f.rows = f.rows[:0]
+	return f.writer.Flush()
+}
+
+// Write the buffered rows of all files
+func (p *parquetPool) flushAll() error {
+	for _, path := range p.paths {
+		if err := p.flush(path, p.outputs[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the remaining rows and the file footers, and close all files
+func (p *parquetPool) Close() error {
+	err := p.flushAll()
+	for _, path := range p.paths {
+		if w := p.outputs[path].writer; w != nil {
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if closeErr := p.files.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Writer into a file of the pool, reopened in append mode after the pool closed it
+type pooledWriter struct {
+	pool *outputPool
+	path string
+}
+
+func (w *pooledWriter) Write(data []byte) (int, error) {
+	writer, _, err := w.pool.Writer(w.path)
+	if err != nil {
+		return 0, err
+	}
+	return writer.Write(data)
+}
+
+// Output file paths of keys, made unique when keys map to the same file name
+type outputPaths struct {
+	dir   string
+	ext   string
+	paths map[string]string // Key -> path
+	used  map[string]struct{}
+}
+
+func newOutputPaths(dir, ext string) *outputPaths {
+	return &outputPaths{
+		dir:   dir,
+		ext:   ext,
+		paths: make(map[string]string),
+		used:  make(map[string]struct{}),
+	}
+}
+
+// Reserve a path for another file (e.g., an index), so that no key gets it
+func (p *outputPaths) Reserve(path string) {
+	p.used[path] = struct{}{}
+}
+
+// Path of the key's file; a numeric suffix is added if the name is already taken
+func (p *outputPaths) Path(key string) string {
+	if path, ok := p.paths[key]; ok {
+		return path
+	}
+	name := safeFileName(key)
+	path := filepath.Join(p.dir, name+p.ext)
+	for i := 2; ; i++ {
+		if _, used := p.used[path]; !used {
+			break
+		}
+		path = filepath.Join(p.dir, fmt.Sprintf("%s_%d%s", name, i, p.ext))
+	}
+	p.used[path] = struct{}{}
+	p.paths[key] = path
+	return path
+}
+
+// Make a key (e.g., sequence ID or sample name) safe to use as a file name
+func safeFileName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return filepath.Clean(name)
+}
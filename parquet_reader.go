@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Magic bytes at the start (and end) of every Parquet file
+const parquetMagic = "PAR1"
+
+// Convert ParquetRecord back to UCRecord
+func (p ParquetRecord) ToUCRecord() UCRecord {
+	var strand *byte
+	if p.Strand != "" && p.Strand != "*" {
+		b := p.Strand[0]
+		strand = &b
+	}
+
+	return UCRecord{
+		RecordType:    p.RecordType,
+		ClusterNumber: p.ClusterNumber,
+		Size:          p.Size,
+		Identity:      p.Identity,
+		Strand:        strand,
+		Unused1:       p.Unused1,
+		Unused2:       p.Unused2,
+		CIGAR:         p.CIGAR,
+		Query:         p.Query,
+		Target:        p.Target,
+	}
+}
+
+// Convert MapRecord back to UCRecord (only Query and Target are known)
+func (m MapRecord) ToUCRecord() UCRecord {
+	return UCRecord{
+		Query:  m.Query,
+		Target: m.Target,
+	}
+}
+
+// Record reader for Parquet files written by ucs
+type parquetRecordReader[T any] struct {
+	reader  *parquet.GenericReader[T]
+	convert func(T) UCRecord
+	split   bool
+	buf     []T
+	pos     int
+	n       int
+	rows    int
+}
+
+func (r *parquetRecordReader[T]) Next() (UCRecord, error) {
+	if r.pos == r.n {
+		n, err := r.reader.Read(r.buf)
+		if n == 0 {
+			if err == nil || err == io.EOF {
+				return UCRecord{}, io.EOF
+			}
+			return UCRecord{}, err
+		}
+		r.pos, r.n = 0, n
+	}
+
+	record := r.convert(r.buf[r.pos])
+	r.pos++
+	r.rows++
+
+	record.QueryLabel = record.Query
+	record.TargetLabel = record.Target
+	record.Query = splitSeqID(record.Query, r.split)
+	record.Target = splitSeqID(record.Target, r.split)
+	return record, nil
+}
+
+func (r *parquetRecordReader[T]) Rows() int {
+	return r.rows
+}
+
+// Open a Parquet record reader on a regular file
+func newParquetFileReader(input *os.File, opts Options) (RecordReader, error) {
+	info, err := input.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet input: %w", err)
+	}
+	return newParquetRecordReader(input, info.Size(), opts)
+}
+
+// Open a Parquet record reader, choosing the schema from the file columns
+func newParquetRecordReader(r io.ReaderAt, size int64, opts Options) (RecordReader, error) {
+	f, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("opening parquet input: %w", err)
+	}
+
+	// Full-record files carry the record_type column, map-only files do not
+	if _, ok := f.Schema().Lookup("record_type"); ok {
+		return &parquetRecordReader[ParquetRecord]{
+			reader:  parquet.NewGenericReader[ParquetRecord](f),
+			convert: ParquetRecord.ToUCRecord,
+			split:   opts.splitSeqID,
+			buf:     make([]ParquetRecord, parquetBatchSize),
+		}, nil
+	}
+
+	if _, ok := f.Schema().Lookup("query"); !ok {
+		return nil, fmt.Errorf("parquet input has no query column")
+	}
+	return &parquetRecordReader[MapRecord]{
+		reader:  parquet.NewGenericReader[MapRecord](f),
+		convert: MapRecord.ToUCRecord,
+		split:   opts.splitSeqID,
+		buf:     make([]MapRecord, parquetBatchSize),
+	}, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sample name of pooled rarefaction curves
+const pooledSample = "all"
+
+// Default interval between rarefaction curve points
+const defaultCurveStep = 1000
+
+// Seeded random source for rarefaction
+func newRarefyRand(seed int) *rand.Rand {
+	return rand.New(rand.NewPCG(uint64(seed), uint64(seed)))
+}
+
+// Total number of reads in the groups
+func totalReads(groups []readGroup) int {
+	total := 0
+	for _, g := range groups {
+		total += int(g.reads)
+	}
+	return total
+}
+
+// Number of marked items among draws taken without replacement from total items,
+// of which marked are marked (hypergeometric distribution).
+// Inversion from the mode takes time proportional to the standard deviation.
+func hypergeometric(rng *rand.Rand, total, marked, draws int) int {
+	lo, hi := max(0, draws+marked-total), min(draws, marked)
+	if lo == hi {
+		return lo
+	}
+
+	mode := min(max(int((float64(draws)+1)*(float64(marked)+1)/(float64(total)+2)), lo), hi)
+	lchoose := func(n, k int) float64 {
+		a, _ := math.Lgamma(float64(n + 1))
+		b, _ := math.Lgamma(float64(k + 1))
+		c, _ := math.Lgamma(float64(n - k + 1))
+		return a - b - c
+	}
+	pMode := math.Exp(lchoose(marked, mode) + lchoose(total-marked, draws-mode) - lchoose(total, draws))
+
+	// Probabilities are accumulated outwards from the mode, alternating sides
+	u := rng.Float64() - pMode
+	left, right := mode, mode
+	pLeft, pRight := pMode, pMode
+	for u > 0 && (left > lo || right < hi) {
+		if right < hi {
+			k := float64(right)
+			pRight *= (float64(marked) - k) * (float64(draws) - k) / ((k + 1) * (float64(total-marked-draws) + k + 1))
+			right++
+			if u -= pRight; u <= 0 {
+				return right
+			}
+		}
+		if left > lo {
+			k := float64(left)
+			pLeft *= k * (float64(total-marked-draws) + k) / ((float64(marked) - k + 1) * (float64(draws) - k + 1))
+			left--
+			if u -= pLeft; u <= 0 {
+				return left
+			}
+		}
+	}
+	// Rounding errors leave a negligible remainder
+	return mode
+}
+
+// Draw n reads without replacement from the read counts (multivariate hypergeometric),
+// removing them from the counts; returns the number of reads drawn from each count
+func drawReads(counts []int, n int, rng *rand.Rand) []int {
+	remaining := 0
+	for _, c := range counts {
+		remaining += c
+	}
+
+	drawn := make([]int, len(counts))
+	for i, c := range counts {
+		if n == 0 {
+			break
+		}
+		k := hypergeometric(rng, remaining, c, n)
+		drawn[i] = k
+		counts[i] -= k
+		remaining -= c
+		n -= k
+	}
+	return drawn
+}
+
+// Draw depth reads without replacement, keeping the group order
+func subsample(groups []readGroup, depth int, rng *rand.Rand) []readGroup {
+	counts := make([]int, len(groups))
+	for i, g := range groups {
+		counts[i] = int(g.reads)
+	}
+
+	var sampled []readGroup
+	for i, picked := range drawReads(counts, depth, rng) {
+		if picked > 0 {
+			g := groups[i]
+			g.reads = float64(picked)
+			sampled = append(sampled, g)
+		}
+	}
+	return sampled
+}
+
+// Rarefy each sample (or all samples together) to the same number of reads.
+// Samples with fewer reads are dropped.
+func (c *sampleCounts) rarefy(depth int, pooled bool, rng *rand.Rand) error {
+	if pooled {
+		if total := totalReads(c.groups); total < depth {
+			return newUCError("Argument", fmt.Sprintf("rarefaction depth %d exceeds the total of %d reads", depth, total), nil)
+		}
+		c.groups = subsample(c.groups, depth, rng)
+	} else {
+		bySample := make(map[string][]readGroup)
+		for _, g := range c.groups {
+			bySample[g.sample] = append(bySample[g.sample], g)
+		}
+
+		// Samples are rarefied in sorted order, so results only depend on the seed
+		c.groups = nil
+		dropped := 0
+		for _, sample := range c.samples {
+			if totalReads(bySample[sample]) < depth {
+				dropped++
+				continue
+			}
+			c.groups = append(c.groups, subsample(bySample[sample], depth, rng)...)
+		}
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "ucs: dropped %d samples with fewer than %d reads\n", dropped, depth)
+		}
+	}
+
+	// Keep only samples with reads left
+	present := make(map[string]struct{})
+	for _, g := range c.groups {
+		present[g.sample] = struct{}{}
+	}
+	samples := c.samples[:0]
+	for _, sample := range c.samples {
+		if _, ok := present[sample]; ok {
+			samples = append(samples, sample)
+		}
+	}
+	c.samples = samples
+	return nil
+}
+
+// Point of a rarefaction curve
+type curvePoint struct {
+	depth int
+	otus  int
+}
+
+// Number of OTUs observed at each depth of nested random subsamples of the reads
+func rarefactionCurve(groups []readGroup, steps []int, step int, rng *rand.Rand) []curvePoint {
+	// Read counts per OTU
+	otuIndex := make(map[string]int)
+	var counts []int
+	total := 0
+	for _, g := range groups {
+		idx, exists := otuIndex[g.target]
+		if !exists {
+			idx = len(counts)
+			otuIndex[g.target] = idx
+			counts = append(counts, 0)
+		}
+		counts[idx] += int(g.reads)
+		total += int(g.reads)
+	}
+
+	// Depths of the curve points, ending with all reads
+	var depths []int
+	if len(steps) > 0 {
+		for _, d := range steps {
+			if d <= total {
+				depths = append(depths, d)
+			}
+		}
+	} else {
+		for d := step; d < total; d += step {
+			depths = append(depths, d)
+		}
+		depths = append(depths, total)
+	}
+
+	// Each depth adds reads drawn from those not yet taken,
+	// as along one random permutation of the reads
+	seen := make([]bool, len(counts))
+	otus, taken := 0, 0
+	points := make([]curvePoint, 0, len(depths))
+	for _, d := range depths {
+		for i, k := range drawReads(counts, d-taken, rng) {
+			if k > 0 && !seen[i] {
+				seen[i] = true
+				otus++
+			}
+		}
+		taken = d
+		points = append(points, curvePoint{d, otus})
+	}
+	return points
+}
+
+// Parse a comma-separated list of increasing depths
+func parseSteps(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var steps []int
+	for _, part := range strings.Split(list, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			return nil, newUCError("Argument", fmt.Sprintf("invalid rarefaction depth %q", part), nil)
+		}
+		if len(steps) > 0 && d <= steps[len(steps)-1] {
+			return nil, newUCError("Argument", "rarefaction depths must be increasing", nil)
+		}
+		steps = append(steps, d)
+	}
+	return steps, nil
+}
+
+// Write rarefaction curves (number of OTUs vs. number of reads) per sample
+func runRarefy(args []string) error {
+	opts := diversityOptions{}
+	var steps string
+	var step int
+	fs := flag.NewFlagSet("rarefy", flag.ExitOnError)
+
+	flagPairs := append(diversityFlags(&opts),
+		flagPair{"steps", "", &steps, "Comma-separated list of curve depths, e.g. 100,500,1000", ""},
+		flagPair{"step", "", &step, "Interval between curve depths (if --steps is not given)", defaultCurveStep},
+	)
+	registerFlags(fs, flagPairs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage:
+  ucs rarefy -i <input.uc.gz> --sample-map <samples.tsv> --step 500 -o <curves.tsv>
+
+Flags:
+`)
+		printFlags(fs, flagPairs)
+	}
+	fs.Parse(args)
+
+	depths, err := parseSteps(steps)
+	if err != nil {
+		return err
+	}
+	if step <= 0 {
+		return newUCError("Argument", "rarefaction step must be positive", nil)
+	}
+	if opts.resolve == resolveFractional {
+		return newUCError("Argument", "rarefaction requires whole reads and cannot be combined with --resolve fractional", nil)
+	}
+
+	counts, err := loadSampleCounts(opts)
+	if err != nil {
+		return err
+	}
+
+	output, err := createOutputFile(opts.outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to create output file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	if err := writeCurves(writer, counts, depths, step, opts.pooled, newRarefyRand(opts.seed)); err != nil {
+		return newUCError("IO", "failed to write rarefaction curves", err)
+	}
+	return writer.Flush()
+}
+
+// Write one line per sample and depth
+func writeCurves(w io.Writer, counts *sampleCounts, depths []int, step int, pooled bool, rng *rand.Rand) error {
+	if _, err := io.WriteString(w, "Sample\tDepth\tOTUs\n"); err != nil {
+		return err
+	}
+
+	curves := make(map[string][]readGroup)
+	samples := counts.samples
+	if pooled {
+		curves[pooledSample] = counts.groups
+		samples = []string{pooledSample}
+	} else {
+		for _, g := range counts.groups {
+			curves[g.sample] = append(curves[g.sample], g)
+		}
+	}
+
+	for _, sample := range samples {
+		for _, p := range rarefactionCurve(curves[sample], depths, step, rng) {
+			if _, err := fmt.Fprintf(w, "%s\t%d\t%d\n", sample, p.depth, p.otus); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Default prefix of new centroid labels
+const defaultRelabelPrefix = "OTU_"
+
+// Cluster statistics used to assign new centroid labels
+type relabelCluster struct {
+	centroid  string
+	number    uint32
+	abundance uint64
+}
+
+// Build the old to new centroid label table for the --relabel mode.
+// Returns the table and the centroids in the order of their new labels.
+func buildRelabelMap(opts Options) (map[string]string, []string, error) {
+	input, err := openInputFile(opts.inputFile)
+	if err != nil {
+		return nil, nil, newUCError("IO", "failed to open input file", err)
+	}
+	defer input.Close()
+
+	// Cluster numbers are only parsed for full records;
+	// warnings are left to the main pass
+	scanOpts := opts
+	scanOpts.mapOnly = false
+	scanOpts.multiMapped = false
+	scanOpts.relabelMap = nil
+	scanOpts.quiet = true
+	reader, err := openRecordReader(input, opts.inputFile, scanOpts)
+	if err != nil {
+		return nil, nil, newUCError("IO", "failed to open input", err)
+	}
+
+	clusters := make(map[string]*relabelCluster)
+	err = processRecords(reader, scanOpts, func(record UCRecord) error {
+		if record.RecordType != "S" && record.RecordType != "H" {
+			return nil
+		}
+		c, exists := clusters[record.Target]
+		if !exists {
+			c = &relabelCluster{centroid: record.Target, number: record.ClusterNumber}
+			clusters[record.Target] = c
+		}
+
+		// Abundance from the size annotation, one read per sequence otherwise
+		if size, ok := labelSize(record.QueryLabel).(uint32); ok {
+			c.abundance += uint64(size)
+		} else {
+			c.abundance++
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ordered := make([]*relabelCluster, 0, len(clusters))
+	for _, c := range clusters {
+		ordered = append(ordered, c)
+	}
+	slices.SortFunc(ordered, func(a, b *relabelCluster) int {
+		if opts.relabel == "abundance" {
+			if n := cmp.Compare(b.abundance, a.abundance); n != 0 {
+				return n
+			}
+		}
+		if n := cmp.Compare(a.number, b.number); n != 0 {
+			return n
+		}
+		return strings.Compare(a.centroid, b.centroid)
+	})
+
+	labels := make(map[string]string, len(ordered))
+	order := make([]string, len(ordered))
+	for i, c := range ordered {
+		order[i] = c.centroid
+		switch opts.relabel {
+		case "cluster":
+			labels[c.centroid] = opts.relabelPrefix + strconv.FormatUint(uint64(c.number)+1, 10)
+		case "abundance":
+			labels[c.centroid] = opts.relabelPrefix + strconv.Itoa(i+1)
+		}
+	}
+
+	if opts.relabel == "hash" {
+		if err := hashLabels(labels, order, opts); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return labels, order, nil
+}
+
+// Label centroids by the SHA1 digest of their sequence
+func hashLabels(labels map[string]string, order []string, opts Options) error {
+	if opts.seqFile == "" {
+		return newUCError("Argument", "relabelling by hash requires a sequence file (--seqs)", nil)
+	}
+
+	input, err := openInputFile(opts.seqFile)
+	if err != nil {
+		return newUCError("IO", "failed to open sequence file", err)
+	}
+	defer input.Close()
+
+	seqs, err := openSeqReader(input, opts.seqFile)
+	if err != nil {
+		return newUCError("IO", "failed to read sequence file", err)
+	}
+
+	centroids := make(map[string]struct{}, len(order))
+	for _, centroid := range order {
+		centroids[centroid] = struct{}{}
+	}
+
+	for {
+		record, err := seqs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newUCError("IO", "failed to read sequence file", err)
+		}
+
+		id := splitSeqID(record.ID(), opts.splitSeqID)
+		if _, ok := centroids[id]; ok {
+			digest := sha1.Sum([]byte(strings.ToUpper(record.seq)))
+			labels[id] = hex.EncodeToString(digest[:])
+		}
+	}
+
+	for _, centroid := range order {
+		if _, ok := labels[centroid]; !ok {
+			return newUCError("Argument", fmt.Sprintf("centroid %s not found in sequence file", centroid), nil)
+		}
+	}
+	return nil
+}
+
+// Replace the ID at the start of a label, keeping its annotations (e.g. ";size=")
+func relabelLabel(label, id, newID string) string {
+	if rest, ok := strings.CutPrefix(label, id); ok {
+		return newID + rest
+	}
+	return newID
+}
+
+// Write the old to new label lookup table
+func writeRelabelTable(path string, order []string, labels map[string]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return newUCError("IO", "failed to create label table", err)
+	}
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	if _, err := writer.WriteString("Centroid\tLabel\n"); err != nil {
+		return newUCError("IO", "failed to write label table", err)
+	}
+	for _, centroid := range order {
+		if _, err := fmt.Fprintf(writer, "%s\t%s\n", centroid, labels[centroid]); err != nil {
+			return newUCError("IO", "failed to write label table", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return newUCError("IO", "failed to write label table", err)
+	}
+	return nil
+}
+
+// Set up centroid relabelling: build the table, write it, and attach it to the options.
+// Stdin is spooled to a temporary file, since the input is read twice.
+// The returned function removes temporary files.
+func prepareRelabel(opts *Options) (func(), error) {
+	cleanup := func() {}
+	switch opts.relabel {
+	case "cluster", "abundance", "hash":
+	default:
+		return cleanup, newUCError("Argument", fmt.Sprintf("unknown relabel mode %q", opts.relabel), nil)
+	}
+
+	tablePath := opts.relabelTable
+	if tablePath == "" {
+		if opts.outputFile == "-" {
+			return cleanup, newUCError("Argument", "label table path (--relabel-table) is required when writing to stdout", nil)
+		}
+		tablePath = opts.outputFile + ".labels.tsv"
+	}
+
+	if opts.inputFile == "-" {
+		path, err := spoolStdin()
+		if err != nil {
+			return cleanup, err
+		}
+		cleanup = func() { os.Remove(path) }
+		opts.inputFile = path
+	}
+
+	labels, order, err := buildRelabelMap(*opts)
+	if err != nil {
+		return cleanup, err
+	}
+	if err := writeRelabelTable(tablePath, order, labels); err != nil {
+		return cleanup, err
+	}
+	opts.relabelMap = labels
+	return cleanup, nil
+}
+
+// Copy stdin into a temporary file so that it can be read more than once
+func spoolStdin() (string, error) {
+	f, err := os.CreateTemp("", "ucs-stdin-*")
+	if err != nil {
+		return "", newUCError("IO", "failed to create temporary file", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		os.Remove(f.Name())
+		return "", newUCError("IO", "failed to read stdin", err)
+	}
+	return f.Name(), nil
+}
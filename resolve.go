@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// Multi-hit resolution policies (--resolve)
+const (
+	resolveBest       = "best"
+	resolveRandom     = "random"
+	resolveFractional = "fractional"
+	resolveAmbiguous  = "ambiguous"
+)
+
+// Target of queries resolved into the shared ambiguous bucket
+const ambiguousTarget = "ambiguous"
+
+// Check the multi-hit resolution options
+func checkResolve(opts Options) error {
+	switch opts.resolve {
+	case "", resolveBest, resolveRandom, resolveFractional, resolveAmbiguous:
+	default:
+		return newUCError("Argument", fmt.Sprintf("unknown resolve policy %q", opts.resolve), nil)
+	}
+	switch opts.tieBreak {
+	case "order", "abundance":
+	default:
+		return newUCError("Argument", fmt.Sprintf("unknown tie-break %q", opts.tieBreak), nil)
+	}
+	if opts.resolve != "" && opts.multiMapped {
+		return newUCError("Argument", "--resolve cannot be combined with --multi-mapped", nil)
+	}
+	return nil
+}
+
+// Hits of all queries, held until every hit of a query is known
+type hitResolver struct {
+	policy      string
+	tieBreak    string
+	rng         *rand.Rand
+	hits        map[string][]UCRecord
+	queries     []string           // In input order
+	targetSizes map[string]float64 // Total abundance of queries mapped to each target
+}
+
+func newHitResolver(opts Options) *hitResolver {
+	seed := uint64(opts.seed)
+	return &hitResolver{
+		policy:      opts.resolve,
+		tieBreak:    opts.tieBreak,
+		rng:         rand.New(rand.NewPCG(seed, seed)),
+		hits:        make(map[string][]UCRecord),
+		targetSizes: make(map[string]float64),
+	}
+}
+
+// Query abundance from the "size=" annotation (1 if not annotated)
+func queryAbundance(r UCRecord) float64 {
+	if size, ok := labelSize(r.QueryLabel).(uint32); ok {
+		return float64(size)
+	}
+	return 1
+}
+
+func (h *hitResolver) add(record UCRecord) {
+	if _, exists := h.hits[record.Query]; !exists {
+		h.queries = append(h.queries, record.Query)
+	}
+	h.hits[record.Query] = append(h.hits[record.Query], record)
+	h.targetSizes[record.Target] += queryAbundance(record)
+}
+
+// Pass resolved records to the handler; returns the number of multi-mapped queries
+func (h *hitResolver) flush(handler func(UCRecord) error) (int, error) {
+	affected := 0
+	for _, query := range h.queries {
+		hits := h.hits[query]
+		if len(hits) > 1 {
+			affected++
+		}
+		for _, record := range h.resolve(hits) {
+			if err := handler(record); err != nil {
+				return affected, newUCError("IO", fmt.Sprintf("failed to write resolved record for query %s", query), err)
+			}
+		}
+	}
+	return affected, nil
+}
+
+// Apply the policy to all hits of one query
+func (h *hitResolver) resolve(hits []UCRecord) []UCRecord {
+	if h.policy == resolveFractional {
+		weight := queryAbundance(hits[0]) / float64(len(hits))
+		for i := range hits {
+			hits[i].Weight = &weight
+		}
+		return hits
+	}
+	if len(hits) == 1 {
+		return hits
+	}
+
+	switch h.policy {
+	case resolveRandom:
+		i := h.rng.IntN(len(hits))
+		return hits[i : i+1]
+	case resolveAmbiguous:
+		record := hits[0]
+		record.Target, record.TargetLabel = ambiguousTarget, ambiguousTarget
+		record.Identity, record.IdentityText = nil, ""
+		return []UCRecord{record}
+	}
+
+	// Best identity; ties keep the first hit or the most abundant target
+	best := 0
+	for i := 1; i < len(hits); i++ {
+		d := hitIdentity(hits[i]) - hitIdentity(hits[best])
+		if d > 0 || (d == 0 && h.tieBreak == "abundance" && h.targetSizes[hits[i].Target] > h.targetSizes[hits[best].Target]) {
+			best = i
+		}
+	}
+	return hits[best : best+1]
+}
+
+// Identity of a hit; records without identity rank last
+func hitIdentity(r UCRecord) float64 {
+	if r.Identity == nil {
+		return -1
+	}
+	return *r.Identity
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Regular expression rule of a sample map
+type sampleRegex struct {
+	re     *regexp.Regexp
+	sample string // May reference capture groups, e.g. "$1"
+}
+
+// Prefix rule of a sample map
+type samplePrefix struct {
+	prefix string
+	sample string
+}
+
+// Assignment of reads to samples by read ID, ID prefix or regular expression
+type sampleMap struct {
+	exact    map[string]string
+	prefixes []samplePrefix // Sorted from the longest prefix
+	regexes  []sampleRegex  // In file order
+}
+
+// Load a sample map from a two-column TSV (read ID pattern, sample).
+// Patterns are exact read IDs, prefixes ending with "*", or regular
+// expressions enclosed in slashes (e.g., "/^(S\d+)_/" with sample "$1").
+func loadSampleMap(fileName string) (*sampleMap, error) {
+	input, err := openInputFile(fileName)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open sample map", err)
+	}
+	defer input.Close()
+
+	scanner, err := createScanner(input, fileName)
+	if err != nil {
+		return nil, newUCError("IO", "failed to read sample map", err)
+	}
+
+	m := &sampleMap{exact: make(map[string]string)}
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return nil, newUCError("Format", fmt.Sprintf("sample map line %d must have a pattern and a sample", lineNum), nil)
+		}
+		pattern, sample := fields[0], fields[1]
+
+		switch {
+		case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, newUCError("Format", fmt.Sprintf("invalid regular expression on sample map line %d", lineNum), err)
+			}
+			m.regexes = append(m.regexes, sampleRegex{re: re, sample: sample})
+		case strings.HasSuffix(pattern, "*"):
+			m.prefixes = append(m.prefixes, samplePrefix{prefix: strings.TrimSuffix(pattern, "*"), sample: sample})
+		default:
+			m.exact[pattern] = sample
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newUCError("IO", "failed to read sample map", err)
+	}
+
+	// Longest prefix wins
+	slices.SortStableFunc(m.prefixes, func(a, b samplePrefix) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	return m, nil
+}
+
+// Sample of a read; exact IDs take precedence over prefixes, prefixes over regexes
+func (m *sampleMap) lookup(id string) (string, bool) {
+	if sample, ok := m.exact[id]; ok {
+		return sample, true
+	}
+	for _, p := range m.prefixes {
+		if strings.HasPrefix(id, p.prefix) {
+			return p.sample, true
+		}
+	}
+	for _, r := range m.regexes {
+		if match := r.re.FindStringSubmatchIndex(id); match != nil {
+			return string(r.re.ExpandString(nil, r.sample, id, match)), true
+		}
+	}
+	return "", false
+}
+
+// Sample of a record, from the sample map or the "sample=" label annotation
+func recordSample(r UCRecord) (string, bool) {
+	if r.Sample != "" {
+		return r.Sample, true
+	}
+	return labelSample(r.QueryLabel)
+}
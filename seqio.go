@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Maximum length of a single line in sequence files
+const maxSeqLineSize = 1 << 30
+
+// Sequence record from a FASTA or FASTQ file
+type seqRecord struct {
+	header string // Header line without the leading '>' or '@'
+	seq    string
+	qual   string // Quality string (FASTQ only)
+}
+
+// Sequence ID is the header up to the first whitespace
+func (r seqRecord) ID() string {
+	if i := strings.IndexAny(r.header, " \t"); i >= 0 {
+		return r.header[:i]
+	}
+	return r.header
+}
+
+// Streaming FASTA/FASTQ reader
+type seqReader struct {
+	scanner *bufio.Scanner
+	fastq   bool
+	next    string // Header of the next FASTA record
+	done    bool
+}
+
+// Open a FASTA/FASTQ file (gzip-aware), detecting the format from the first record
+func openSeqReader(input *os.File, fileName string) (*seqReader, error) {
+	scanner, err := createScanner(input, fileName)
+	if err != nil {
+		return nil, err
+	}
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSeqLineSize)
+
+	r := &seqReader{scanner: scanner}
+	line, ok := r.nextLine()
+	if !ok {
+		r.done = true
+		return r, scanner.Err()
+	}
+	switch line[0] {
+	case '>':
+		r.next = line[1:]
+	case '@':
+		r.fastq = true
+		r.next = line[1:]
+	default:
+		return nil, fmt.Errorf("unrecognized sequence format in %s", fileName)
+	}
+	return r, nil
+}
+
+// Next non-empty line; blank lines between records are skipped
+func (r *seqReader) nextLine() (string, bool) {
+	for {
+		line, ok := r.line()
+		if !ok || line != "" {
+			return line, ok
+		}
+	}
+}
+
+// Next line, which may be empty (e.g., a FASTQ record with an empty sequence)
+func (r *seqReader) line() (string, bool) {
+	if !r.scanner.Scan() {
+		return "", false
+	}
+	return strings.TrimRight(r.scanner.Text(), "\r"), true
+}
+
+// Next returns the next sequence record, or io.EOF at the end of the file
+func (r *seqReader) Next() (seqRecord, error) {
+	if r.done {
+		if err := r.scanner.Err(); err != nil {
+			return seqRecord{}, err
+		}
+		return seqRecord{}, io.EOF
+	}
+
+	if r.fastq {
+		return r.nextFASTQ()
+	}
+	return r.nextFASTA()
+}
+
+func (r *seqReader) nextFASTA() (seqRecord, error) {
+	record := seqRecord{header: r.next}
+
+	// Sequence may span multiple lines
+	var seq strings.Builder
+	for {
+		line, ok := r.nextLine()
+		if !ok {
+			r.done = true
+			break
+		}
+		if line[0] == '>' {
+			r.next = line[1:]
+			break
+		}
+		seq.WriteString(line)
+	}
+	record.seq = seq.String()
+	return record, nil
+}
+
+func (r *seqReader) nextFASTQ() (seqRecord, error) {
+	record := seqRecord{header: r.next}
+
+	// Sequence and quality lines of the 4-line record may be empty
+	seq, ok1 := r.line()
+	plus, ok2 := r.line()
+	qual, ok3 := r.line()
+	if !ok1 || !ok2 || !ok3 || !strings.HasPrefix(plus, "+") {
+		return seqRecord{}, fmt.Errorf("truncated FASTQ record %s", record.ID())
+	}
+	record.seq = seq
+	record.qual = qual
+
+	if line, ok := r.nextLine(); ok {
+		if line[0] != '@' {
+			return seqRecord{}, fmt.Errorf("malformed FASTQ record after %s", record.ID())
+		}
+		r.next = line[1:]
+	} else {
+		r.done = true
+	}
+	return record, nil
+}
+
+// Write a sequence record with the given header in FASTA or FASTQ format
+func writeSeqRecord(w io.Writer, record seqRecord, header string, fastq bool) error {
+	var err error
+	if fastq {
+		_, err = fmt.Fprintf(w, "@%s\n%s\n+\n%s\n", header, record.seq, record.qual)
+	} else {
+		_, err = fmt.Fprintf(w, ">%s\n%s\n", header, record.seq)
+	}
+	return err
+}
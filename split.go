@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/briandowns/spinner"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Key for records without an assigned sample
+const unassignedSample = "unassigned"
+
+// Name of the index file listing all split outputs
+const splitIndexFile = "index.tsv"
+
+// Output file of one split key
+type splitOutput struct {
+	key     string
+	path    string
+	records int
+}
+
+// Function returning the split key of a record
+func splitKeyFunc(splitBy string) (func(UCRecord) string, error) {
+	switch splitBy {
+	case "sample":
+		return func(r UCRecord) string {
+			if sample, ok := recordSample(r); ok {
+				return sample
+			}
+			return unassignedSample
+		}, nil
+	case "target":
+		return func(r UCRecord) string { return r.Target }, nil
+	case "cluster":
+		return func(r UCRecord) string { return strconv.FormatUint(uint64(r.ClusterNumber), 10) }, nil
+	}
+	return nil, newUCError("Argument", fmt.Sprintf("unknown split mode %q", splitBy), nil)
+}
+
+// Process UC-file and write one output file (TSV or Parquet) per key into the output directory
+func processAndWriteSplit(input *os.File, opts Options, s *spinner.Spinner) error {
+	keyOf, err := splitKeyFunc(opts.splitBy)
+	if err != nil {
+		return err
+	}
+
+	format := outputFormat(opts)
+	if format != "tsv" && format != "parquet" {
+		return newUCError("Argument", fmt.Sprintf("split output supports tsv and parquet formats, not %s", format), nil)
+	}
+
+	dir := opts.outputFile
+	if dir == "-" {
+		return newUCError("Argument", "split output requires an output directory (-o)", nil)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return newUCError("IO", "failed to create output directory", err)
+	}
+
+	header, columns, err := textHeader(opts)
+	if err != nil {
+		return err
+	}
+
+	// Cluster numbers are only parsed for full records; the output keeps its layout
+	readOpts := opts
+	if opts.splitBy == "cluster" {
+		readOpts.mapOnly = false
+	}
+	reader, err := openRecordReader(input, opts.inputFile, readOpts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	ext := ".tsv"
+	if format == "parquet" {
+		ext = ".parquet"
+	}
+
+	// Outputs in the order keys were first seen; file names are made unique,
+	// and the index file name is never given to a key
+	outputs := make(map[string]*splitOutput)
+	var order []*splitOutput
+	paths := newOutputPaths(dir, ext)
+	paths.Reserve(filepath.Join(dir, splitIndexFile))
+	outputFor := func(key string) *splitOutput {
+		if out, ok := outputs[key]; ok {
+			return out
+		}
+		out := &splitOutput{key: key, path: paths.Path(key)}
+		outputs[key] = out
+		order = append(order, out)
+		return out
+	}
+
+	// Records are streamed through a pool of open files
+	var write func(*splitOutput, UCRecord) error
+	var closeFiles func() error
+	if format == "parquet" {
+		schema, toRow, err := parquetLayout(opts)
+		if err != nil {
+			return err
+		}
+		pool := newParquetPool(opts.maxOpen, parquetBatchSize, schema, toRow)
+		write = func(out *splitOutput, record UCRecord) error {
+			return pool.Write(out.path, record)
+		}
+		closeFiles = pool.Close
+	} else {
+		pool := newOutputPool(opts.maxOpen)
+		write = func(out *splitOutput, record UCRecord) error {
+			w, created, err := pool.Writer(out.path)
+			if err != nil {
+				return err
+			}
+			if created && !opts.noHeader {
+				if _, err := w.WriteString(header); err != nil {
+					return err
+				}
+			}
+			return writeUCRecord(w, record, opts, columns)
+		}
+		closeFiles = pool.Close
+	}
+
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		out := outputFor(keyOf(record))
+		out.records++
+		return write(out, record)
+	}, s)
+	if closeErr := closeFiles(); err == nil && closeErr != nil {
+		err = newUCError("IO", "failed to close output files", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeSplitIndex(filepath.Join(dir, splitIndexFile), order)
+}
+
+// Parquet schema and record conversion, as in single-file Parquet output
+func parquetLayout(opts Options) (*parquet.Schema, func(parquet.Row, UCRecord) parquet.Row, error) {
+	if customColumns(opts) {
+		columns, err := parseColumns(opts.columns, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return parquetSchema(columns), func(row parquet.Row, record UCRecord) parquet.Row {
+			return parquetRow(row, record, columns)
+		}, nil
+	}
+
+	if opts.mapOnly {
+		schema := parquet.SchemaOf(MapRecord{})
+		return schema, func(row parquet.Row, record UCRecord) parquet.Row {
+			return schema.Deconstruct(row[:0], MapRecord{Query: record.Query, Target: record.Target})
+		}, nil
+	}
+
+	schema := parquet.SchemaOf(ParquetRecord{})
+	return schema, func(row parquet.Row, record UCRecord) parquet.Row {
+		return schema.Deconstruct(row[:0], record.ToParquet())
+	}, nil
+}
+
+// Write the index of split outputs (key, file, number of records)
+func writeSplitIndex(path string, order []*splitOutput) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return newUCError("IO", "failed to create index file", err)
+	}
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	fmt.Fprintf(writer, "Key\tFile\tRecords\n")
+	for _, out := range order {
+		fmt.Fprintf(writer, "%s\t%s\t%d\n", out.key, filepath.Base(out.path), out.records)
+	}
+	if err := writer.Flush(); err != nil {
+		return newUCError("IO", "failed to write index file", err)
+	}
+	return nil
+}
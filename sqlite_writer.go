@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/briandowns/spinner"
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver (no cgo)
+)
+
+// SQLite schema, indexes are created after the data is loaded.
+// Mappings are a view of the records table, which is indexed by query and target
+const sqliteSchema = `
+CREATE TABLE records (
+	record_type    TEXT,
+	cluster_number INTEGER,
+	size           INTEGER,
+	identity       REAL,
+	strand         TEXT,
+	unused_1       TEXT,
+	unused_2       TEXT,
+	cigar          TEXT,
+	query          TEXT NOT NULL,
+	target         TEXT NOT NULL
+);
+CREATE VIEW mappings AS SELECT query, target FROM records;
+CREATE TABLE clusters (
+	cluster_number INTEGER PRIMARY KEY,
+	centroid       TEXT NOT NULL,
+	size           INTEGER NOT NULL
+);
+`
+
+const sqliteIndexes = `
+CREATE INDEX records_query_idx ON records (query);
+CREATE INDEX records_target_idx ON records (target);
+CREATE INDEX clusters_centroid_idx ON clusters (centroid);
+`
+
+// Process UC-file and write output into SQLite database
+func processAndWriteSQLite(input *os.File, outputFile string, opts Options, s *spinner.Spinner) error {
+	if outputFile == "-" {
+		return newUCError("Argument", "SQLite output requires an output file", nil)
+	}
+
+	reader, err := openRecordReader(input, opts.inputFile, opts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	db, err := sql.Open("sqlite", outputFile)
+	if err != nil {
+		return newUCError("IO", "failed to open SQLite database", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return newUCError("IO", "failed to create SQLite tables", err)
+	}
+
+	// Load all data in a single transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return newUCError("IO", "failed to begin SQLite transaction", err)
+	}
+	defer tx.Rollback()
+
+	insertRecord, err := tx.Prepare(`INSERT INTO records VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return newUCError("IO", "failed to prepare SQLite statement", err)
+	}
+	defer insertRecord.Close()
+
+	clusters := make(map[uint32]*ucCluster)
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		trackCluster(clusters, record)
+
+		var strand *string
+		if record.Strand != nil {
+			str := string(*record.Strand)
+			strand = &str
+		}
+		_, err := insertRecord.Exec(
+			record.RecordType, record.ClusterNumber, record.Size, record.Identity, strand,
+			record.Unused1, record.Unused2, record.CIGAR, record.Query, record.Target)
+		return err
+	}, s)
+	if err != nil {
+		return err
+	}
+
+	insertCluster, err := tx.Prepare(`INSERT INTO clusters VALUES (?, ?, ?)`)
+	if err != nil {
+		return newUCError("IO", "failed to prepare SQLite statement", err)
+	}
+	defer insertCluster.Close()
+
+	for number, c := range clusters {
+		if _, err := insertCluster.Exec(number, c.centroid, c.size); err != nil {
+			return newUCError("IO", fmt.Sprintf("failed to write cluster %d", number), err)
+		}
+	}
+
+	if _, err := tx.Exec(sqliteIndexes); err != nil {
+		return newUCError("IO", "failed to create SQLite indexes", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return newUCError("IO", "failed to commit SQLite transaction", err)
+	}
+	return nil
+}
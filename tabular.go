@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Text input formats (--input-format)
+const (
+	inputAuto    = "auto"
+	inputUC      = "uc"
+	inputBlast6  = "blast6"  // VSEARCH --blast6out
+	inputUserout = "userout" // VSEARCH --userout with --userfields
+)
+
+// Number of columns of BLAST6 tabular output
+const blast6Columns = 12
+
+// Text input format options, shared by all commands
+type inputOptions struct {
+	inputFormat string
+	userFields  string
+}
+
+// Flags of the text input format
+func inputFlags(opts *inputOptions) []flagPair {
+	return []flagPair{
+		{"input-format", "", &opts.inputFormat, "Text input format: uc, blast6, userout (default: auto-detect)", inputAuto},
+		{"userfields", "", &opts.userFields, "Fields of --userout input, e.g. query+target+id (as in VSEARCH --userfields)", ""},
+	}
+}
+
+// Check the input format options
+func checkInputFormat(opts inputOptions) error {
+	switch opts.inputFormat {
+	case "", inputAuto, inputUC, inputBlast6:
+	case inputUserout:
+		if opts.userFields == "" {
+			return newUCError("Argument", "--userout input requires the list of fields (--userfields)", nil)
+		}
+	default:
+		return newUCError("Argument", fmt.Sprintf("unknown input format %q", opts.inputFormat), nil)
+	}
+	if opts.userFields != "" {
+		_, err := parseUserFields(opts.userFields)
+		return err
+	}
+	return nil
+}
+
+// Input format from the option, the file extension, or the first line of the input.
+// The first line is only recognised if it fits into the reader's buffer.
+func detectInputFormat(text *bufio.Reader, fileName string, opts Options) string {
+	if opts.inputFormat != "" && opts.inputFormat != inputAuto {
+		return opts.inputFormat
+	}
+	if opts.userFields != "" {
+		return inputUserout
+	}
+
+	name := strings.TrimSuffix(fileName, ".gz")
+	for _, ext := range []string{".b6", ".blast6", ".blast6out", ".m8"} {
+		if strings.HasSuffix(name, ext) {
+			return inputBlast6
+		}
+	}
+
+	// UC records have 10 columns, BLAST6 records 12 with the identity third
+	data, _ := text.Peek(text.Size())
+	line, _, found := strings.Cut(string(data), "\n")
+	if !found && len(data) == text.Size() {
+		return inputUC
+	}
+	fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+	if len(fields) == blast6Columns {
+		if _, err := strconv.ParseFloat(fields[2], 64); err == nil {
+			return inputBlast6
+		}
+	}
+	return inputUC
+}
+
+// Record reader over tabular search output
+type tabularReader struct {
+	scanner *bufio.Scanner
+	parse   func(line string) (UCRecord, bool)
+	rows    int
+}
+
+func (r *tabularReader) Next() (UCRecord, error) {
+	for r.scanner.Scan() {
+		r.rows++
+		if record, ok := r.parse(r.scanner.Text()); ok {
+			return record, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return UCRecord{}, err
+	}
+	return UCRecord{}, io.EOF
+}
+
+func (r *tabularReader) Rows() int {
+	return r.rows
+}
+
+// Hit (H) or, for queries without hits ("*" target), no-hit (N) record
+func tabularRecord(query, target string, split bool) UCRecord {
+	record := UCRecord{
+		RecordType:  "H",
+		Query:       splitSeqID(query, split),
+		Target:      splitSeqID(target, split),
+		QueryLabel:  query,
+		TargetLabel: target,
+	}
+	// As in UC input, N records use the query as target
+	if target == "*" {
+		record.RecordType = "N"
+		record.Target, record.TargetLabel = record.Query, query
+	}
+	return record
+}
+
+// Parse identity into the record, keeping the text as written
+func setIdentity(record *UCRecord, text string) {
+	if identity, err := strconv.ParseFloat(text, 64); err == nil {
+		record.Identity = &identity
+		record.IdentityText = text
+	}
+}
+
+// Parse a BLAST6 line: qseqid, sseqid, pident, length, mismatch, gapopen,
+// qstart, qend, sstart, send, evalue, bitscore
+func parseBlast6Record(line string, split bool) (UCRecord, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < blast6Columns || strings.HasPrefix(line, "#") {
+		return UCRecord{}, false
+	}
+
+	record := tabularRecord(fields[0], fields[1], split)
+	if record.RecordType == "H" {
+		setIdentity(&record, fields[2])
+	}
+	return record, true
+}
+
+// Column positions of the --userout fields used in records (-1 if absent)
+type userFields struct {
+	count    int
+	query    int
+	target   int
+	identity int
+	strand   int
+	length   int
+	cigar    int
+}
+
+// Parse a VSEARCH --userfields list, e.g. "query+target+id+alnlen+qstrand"
+func parseUserFields(list string) (*userFields, error) {
+	f := &userFields{query: -1, target: -1, identity: -1, strand: -1, length: -1, cigar: -1}
+	names := strings.Split(list, "+")
+	f.count = len(names)
+	for i, name := range names {
+		switch name {
+		case "query":
+			f.query = i
+		case "target":
+			f.target = i
+		case "id":
+			f.identity = i
+		case "id0", "id1", "id2", "id3", "id4":
+			// Alternative identity definitions are used if "id" is not given
+			if f.identity < 0 || names[f.identity] != "id" {
+				f.identity = i
+			}
+		case "qstrand":
+			f.strand = i
+		case "ql":
+			f.length = i
+		case "caln":
+			f.cigar = i
+		}
+	}
+	if f.query < 0 || f.target < 0 {
+		return nil, newUCError("Argument", "--userfields must include query and target", nil)
+	}
+	return f, nil
+}
+
+// Parse a --userout line with the configured fields
+func (f *userFields) parse(line string, split bool) (UCRecord, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < f.count {
+		return UCRecord{}, false
+	}
+
+	record := tabularRecord(fields[f.query], fields[f.target], split)
+	if record.RecordType == "N" {
+		return record, true
+	}
+	if f.identity >= 0 {
+		setIdentity(&record, fields[f.identity])
+	}
+	if f.strand >= 0 && fields[f.strand] != "" && fields[f.strand] != "*" {
+		strand := fields[f.strand][0]
+		record.Strand = &strand
+	}
+	if f.length >= 0 {
+		if length, err := strconv.ParseUint(fields[f.length], 10, 32); err == nil {
+			record.Size = uint32(length)
+		}
+	}
+	if f.cigar >= 0 {
+		record.CIGAR = fields[f.cigar]
+	}
+	return record, true
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Taxonomic ranks in canonical order, keyed by their SINTAX/QIIME prefix
+var taxRanks = []struct {
+	prefix string
+	name   string
+}{
+	{"d", "domain"},
+	{"k", "kingdom"},
+	{"p", "phylum"},
+	{"c", "class"},
+	{"o", "order"},
+	{"f", "family"},
+	{"g", "genus"},
+	{"s", "species"},
+}
+
+// Ranks assumed for taxonomy strings without rank prefixes
+var positionalRanks = []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+// Taxon name at one rank, with optional confidence (-1 if not given)
+type taxon struct {
+	rank       string
+	name       string
+	confidence float64
+}
+
+// Taxonomy assignments keyed by centroid ID
+type taxonomyTable struct {
+	assignments map[string][]taxon
+	ranks       []string // Ranks present in the table, in canonical order
+	split       bool     // Split sequence IDs at semicolon
+}
+
+// Load SINTAX tabular output or a generic two-column TSV (ID, taxonomy).
+// Ranks with confidence below the cutoff (and all ranks below them) are dropped.
+func loadTaxonomy(fileName string, cutoff float64, split bool) (*taxonomyTable, error) {
+	input, err := openInputFile(fileName)
+	if err != nil {
+		return nil, newUCError("IO", "failed to open taxonomy file", err)
+	}
+	defer input.Close()
+
+	scanner, err := createScanner(input, fileName)
+	if err != nil {
+		return nil, newUCError("IO", "failed to read taxonomy file", err)
+	}
+
+	table := &taxonomyTable{
+		assignments: make(map[string][]taxon),
+		split:       split,
+	}
+	seenRanks := make(map[string]struct{})
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			return nil, newUCError("Format", fmt.Sprintf("taxonomy file line %d has fewer than 2 columns", lineNum), nil)
+		}
+
+		// Skip a header line of a generic table (e.g., "Feature ID<TAB>Taxon")
+		if lineNum == 1 && isTaxonomyHeader(fields[1]) {
+			continue
+		}
+
+		// Rank columns are kept even if all names at a rank fall below the cutoff
+		taxa := parseTaxonomy(fields[1])
+		for _, t := range taxa {
+			seenRanks[t.rank] = struct{}{}
+		}
+		table.assignments[splitSeqID(fields[0], split)] = applyConfidenceCutoff(taxa, cutoff)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newUCError("IO", "failed to read taxonomy file", err)
+	}
+
+	for _, r := range taxRanks {
+		if _, ok := seenRanks[r.name]; ok {
+			table.ranks = append(table.ranks, r.name)
+		}
+	}
+	return table, nil
+}
+
+func isTaxonomyHeader(field string) bool {
+	switch strings.ToLower(field) {
+	case "taxon", "taxonomy":
+		return true
+	}
+	return false
+}
+
+// Parse a taxonomy string, e.g. "d:Bacteria(1.00),p:Firmicutes(0.87)" (SINTAX),
+// "k__Fungi;p__Ascomycota" (QIIME/UNITE), or "Fungi;Ascomycota" (positional)
+func parseTaxonomy(s string) []taxon {
+	sep := ";"
+	if strings.Contains(s, ":") && !strings.Contains(s, ";") {
+		sep = ","
+	}
+
+	var taxa []taxon
+	for i, part := range strings.Split(s, sep) {
+		part = strings.TrimSpace(part)
+		t := taxon{confidence: -1}
+
+		// Confidence in parentheses at the end
+		if open := strings.LastIndex(part, "("); open > 0 && strings.HasSuffix(part, ")") {
+			if conf, err := strconv.ParseFloat(part[open+1:len(part)-1], 64); err == nil {
+				t.confidence = conf
+				part = part[:open]
+			}
+		}
+
+		// Rank prefix ("d:" or "k__"), otherwise the rank follows from the position
+		if prefix, name, ok := strings.Cut(part, "__"); ok && len(prefix) == 1 {
+			t.rank, t.name = rankName(prefix), name
+		} else if prefix, name, ok := strings.Cut(part, ":"); ok && len(prefix) == 1 {
+			t.rank, t.name = rankName(prefix), name
+		} else if i < len(positionalRanks) {
+			t.rank, t.name = positionalRanks[i], part
+		} else {
+			continue
+		}
+
+		if t.name != "" {
+			taxa = append(taxa, t)
+		}
+	}
+	return taxa
+}
+
+// Rank name for a rank prefix
+func rankName(prefix string) string {
+	for _, r := range taxRanks {
+		if r.prefix == prefix {
+			return r.name
+		}
+	}
+	return prefix
+}
+
+// Keep ranks up to the first one with confidence below the cutoff
+func applyConfidenceCutoff(taxa []taxon, cutoff float64) []taxon {
+	if cutoff <= 0 {
+		return taxa
+	}
+	for i, t := range taxa {
+		if t.confidence >= 0 && t.confidence < cutoff {
+			return taxa[:i]
+		}
+	}
+	return taxa
+}
+
+// Taxonomy of the record target (looked up by the original centroid ID)
+func (t *taxonomyTable) lookup(r UCRecord) ([]taxon, bool) {
+	key := r.Target
+	if r.TargetLabel != "" {
+		key = splitSeqID(r.TargetLabel, t.split)
+	}
+	taxa, ok := t.assignments[key]
+	return taxa, ok
+}
+
+// Output columns: the full (filtered) taxonomy string and one column per rank
+func (t *taxonomyTable) columns() []ucColumn {
+	columns := []ucColumn{
+		{"taxonomy", stringColumn, true, func(r UCRecord) any {
+			taxa, ok := t.lookup(r)
+			if !ok {
+				return nil
+			}
+			parts := make([]string, len(taxa))
+			for i, tx := range taxa {
+				parts[i] = rankPrefix(tx.rank) + ":" + tx.name
+			}
+			return strings.Join(parts, ",")
+		}},
+	}
+
+	for _, rank := range t.ranks {
+		columns = append(columns, ucColumn{rank, stringColumn, true, func(r UCRecord) any {
+			taxa, _ := t.lookup(r)
+			for _, tx := range taxa {
+				if tx.rank == rank {
+					return tx.name
+				}
+			}
+			return nil
+		}})
+	}
+	return columns
+}
+
+// Rank prefix for a rank name
+func rankPrefix(rank string) string {
+	for _, r := range taxRanks {
+		if r.name == rank {
+			return r.prefix
+		}
+	}
+	return rank
+}
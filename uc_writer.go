@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/briandowns/spinner"
+)
+
+// Cluster centroid and size, used to regenerate C records
+type ucCluster struct {
+	centroid string
+	label    string // Centroid label with annotations, for C records
+	size     uint32
+}
+
+// Process UC-file and write output back into UC format
+func processAndWriteUC(input *os.File, writer *bufio.Writer, opts Options, s *spinner.Spinner) error {
+	reader, err := openRecordReader(input, opts.inputFile, opts)
+	if err != nil {
+		return newUCError("IO", "failed to open input", err)
+	}
+
+	// Unmatched queries are written back as N records, unless collected by --unmatched
+	opts.keepN = true
+
+	clusters := make(map[uint32]*ucCluster)
+	clustered := false
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		if record.RecordType == "S" {
+			clustered = true
+			// The S record of a relabelled centroid carries the new label
+			if opts.relabelMap != nil {
+				record.QueryLabel = relabelLabel(record.QueryLabel, record.Query, record.Target)
+				record.Query = record.Target
+			}
+		}
+		trackCluster(clusters, record)
+		return writeUCLine(writer, record)
+	}, s)
+	if err != nil {
+		return err
+	}
+
+	// Cluster numbers of searches refer to database targets, so there are no C records
+	if isSearch(opts.mode, clustered) {
+		return nil
+	}
+	return writeCRecords(writer, clusters)
+}
+
+// Write C records at the end of the file, ordered by cluster number
+func writeCRecords(writer *bufio.Writer, clusters map[uint32]*ucCluster) error {
+	numbers := make([]uint32, 0, len(clusters))
+	for number := range clusters {
+		numbers = append(numbers, number)
+	}
+	slices.Sort(numbers)
+
+	for _, number := range numbers {
+		c := clusters[number]
+		_, err := fmt.Fprintf(writer, "C\t%d\t%d\t*\t*\t*\t*\t*\t%s\t*\n", number, c.size, c.label)
+		if err != nil {
+			return newUCError("IO", fmt.Sprintf("failed to write C record for cluster %d", number), err)
+		}
+	}
+	return nil
+}
+
+// Update cluster centroid and size from an S or H record
+func trackCluster(clusters map[uint32]*ucCluster, record UCRecord) {
+	switch record.RecordType {
+	case "S", "H":
+	default:
+		return
+	}
+
+	c, exists := clusters[record.ClusterNumber]
+	if !exists {
+		c = &ucCluster{}
+		clusters[record.ClusterNumber] = c
+	}
+	c.size++
+
+	// The seed defines the centroid; hits only fill it in if the seed is missing
+	if record.RecordType == "S" || c.centroid == "" {
+		c.centroid = record.Target
+		c.label = ucLabel(record.Target, record.TargetLabel)
+	}
+}
+
+// Write a single record as a 10-column UC line
+func writeUCLine(writer *bufio.Writer, record UCRecord) error {
+	// Records without a type (e.g., multi-mapped pairs) are written as hits
+	recordType := record.RecordType
+	if recordType == "" {
+		recordType = "H"
+	}
+
+	// S and N records have no target
+	target := ucLabel(record.Target, record.TargetLabel)
+	if recordType == "S" || recordType == "N" {
+		target = "*"
+	}
+
+	// N records have neither cluster number nor size
+	cluster, size := strconv.FormatUint(uint64(record.ClusterNumber), 10), strconv.FormatUint(uint64(record.Size), 10)
+	if recordType == "N" {
+		cluster, size = "*", "*"
+	}
+
+	_, err := fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		recordType, cluster, size,
+		ucIdentity(record), ucStrand(record),
+		ucField(record.Unused1), ucField(record.Unused2), ucField(record.CIGAR),
+		ucLabel(record.Query, record.QueryLabel), target)
+	return err
+}
+
+// Original label (with annotations) of a sequence ID, unless the ID was renamed
+func ucLabel(id, label string) string {
+	if label != "" && splitSeqID(label, true) == id {
+		return label
+	}
+	return id
+}
+
+// Identity as written in the input, or with VSEARCH precision if unknown
+func ucIdentity(record UCRecord) string {
+	if record.Identity == nil {
+		return "*"
+	}
+	if record.IdentityText != "" {
+		return record.IdentityText
+	}
+	return strconv.FormatFloat(*record.Identity, 'f', 1, 64)
+}
+
+func ucStrand(record UCRecord) string {
+	if record.Strand == nil {
+		return "*"
+	}
+	return string(*record.Strand)
+}
+
+// Empty fields are written as "*"
+func ucField(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return value
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -42,14 +46,42 @@ func newUCError(errType, msg string, err error) *UCError {
 
 // A type to store command options
 type Options struct {
-	inputFile   string
-	outputFile  string
-	summary     bool
-	mapOnly     bool
-	splitSeqID  bool
-	removeDups  bool
-	multiMapped bool
-	version     bool
+	inputFile  string
+	outputFile string
+	format     string
+	inputOptions
+	mode          string
+	columns       string
+	jsonFields    string
+	relabel       string
+	relabelPrefix string
+	relabelTable  string
+	seqFile       string
+	querySeqFile  string
+	taxonomyFile  string
+	taxCutoff     float64
+	sampleMapFile string
+	unmatchedFile string
+	splitBy       string
+	maxOpen       int
+	delimiter     string
+	noHeader      bool
+	summary       bool
+	mapOnly       bool
+	splitSeqID    bool
+	removeDups    bool
+	multiMapped   bool
+	resolve       string
+	tieBreak      string
+	seed          int
+	version       bool
+
+	relabelMap map[string]string // Old to new centroid labels (set by --relabel)
+	taxonomy   *taxonomyTable    // Centroid taxonomy (set by --taxonomy)
+	sampleMap  *sampleMap        // Read to sample assignment (set by --sample-map)
+	unmatched  *unmatchedQueries // Unmatched queries (set by --unmatched)
+	quiet      bool              // No warnings (set for preliminary passes over the input)
+	keepN      bool              // Pass N records on in any mode (UC output, diff)
 }
 
 // UC record type
@@ -58,12 +90,17 @@ type UCRecord struct {
 	ClusterNumber uint32   // Field 1: Cluster number
 	Size          uint32   // Field 2: Sequence length/cluster size
 	Identity      *float64 // Field 3: % identity with centroid
+	IdentityText  string   // Field 3 as written in the input
 	Strand        *byte    // Field 4: Strand +/-
 	Unused1       string   // Field 5: unused
 	Unused2       string   // Field 6: unused
 	CIGAR         string   // Field 7: CIGAR string
 	Query         string   // Field 8: Query sequence ID
 	Target        string   // Field 9: Target/centroid sequence ID
+	QueryLabel    string   // Full query label, including annotations
+	TargetLabel   string   // Full target label, including annotations
+	Sample        string   // Sample of the query (from --sample-map)
+	Weight        *float64 // Share of the query abundance (from --resolve fractional)
 }
 
 // A type for Parquet output (all columns)
@@ -153,11 +190,111 @@ func fatalError(format string, a ...interface{}) {
 
 	msg := fmt.Sprintf(format, a...)
 	fmt.Fprintf(os.Stderr, red+"Error: %s"+reset+"\n", msg)
+	runExitCleanups()
 	os.Exit(1)
 }
 
+// Cleanup functions (e.g., removal of temporary files) that must also run
+// when fatalError exits, since os.Exit skips deferred calls
+var exitCleanups []func()
+
+// Run the cleanup functions in reverse order of registration
+func runExitCleanups() {
+	for i := len(exitCleanups) - 1; i >= 0; i-- {
+		exitCleanups[i]()
+	}
+	exitCleanups = nil
+}
+
+// Subcommand with its own flags
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// Available subcommands
+var commands = []command{
+	{"extract", "Extract cluster member sequences from FASTA/FASTQ", runExtract},
+	{"graph", "Export the query-centroid graph (GraphML, GEXF, DOT, edge list)", runGraph},
+	{"components", "Report connected components of targets sharing queries", runComponents},
+	{"alpha", "Compute alpha diversity per sample", runAlpha},
+	{"rarefy", "Write rarefaction curves per sample", runRarefy},
+	{"beta", "Compute beta-diversity distances between samples", runBeta},
+	{"identity", "Summarise identities of hits per cluster", runIdentity},
+	{"diff", "Compare two UC files record by record", runDiff},
+	{"merge", "Merge UC files from chunked runs", runMerge},
+}
+
 func main() {
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		for _, c := range commands {
+			if os.Args[1] == c.name {
+				if err := c.run(os.Args[2:]); err != nil {
+					// Commands may exit with their own status
+					var status *exitStatus
+					if errors.As(err, &status) {
+						if status.err != nil {
+							fmt.Fprintf(os.Stderr, "\033[31mError: %v\033[0m\n", status.err)
+						}
+						os.Exit(status.code)
+					}
+					fatalError("%v", err)
+				}
+				return
+			}
+		}
+	}
+
 	opts := parseFlags()
+	if err := checkResolve(opts); err != nil {
+		fatalError("%v", err)
+	}
+	if err := checkMode(opts.mode); err != nil {
+		fatalError("%v", err)
+	}
+	if err := checkInputFormat(opts.inputOptions); err != nil {
+		fatalError("%v", err)
+	}
+	if !opts.summary {
+		if err := checkColumns(outputFormat(opts), opts); err != nil {
+			fatalError("%v", err)
+		}
+	}
+
+	// Load centroid taxonomy
+	if opts.taxonomyFile != "" && !opts.summary {
+		taxonomy, err := loadTaxonomy(opts.taxonomyFile, opts.taxCutoff, opts.splitSeqID)
+		if err != nil {
+			fatalError("%v", err)
+		}
+		opts.taxonomy = taxonomy
+	}
+
+	// Load read to sample assignment
+	if opts.sampleMapFile != "" {
+		sampleMap, err := loadSampleMap(opts.sampleMapFile)
+		if err != nil {
+			fatalError("%v", err)
+		}
+		opts.sampleMap = sampleMap
+	}
+
+	// Build the centroid label table before the main pass
+	if opts.relabel != "" && !opts.summary {
+		cleanup, err := prepareRelabel(&opts)
+		exitCleanups = append(exitCleanups, cleanup)
+		defer runExitCleanups()
+		if err != nil {
+			fatalError("%v", err)
+		}
+	}
+
+	// Unmatched queries are collected during the main pass or the summary
+	if opts.unmatchedFile != "" {
+		opts.unmatched = newUnmatchedQueries()
+	}
 
 	// Create and start spinner
 	s := createSpinner()
@@ -174,17 +311,23 @@ func main() {
 	}
 	defer input.Close()
 
-	output, err := createOutputFile(opts.outputFile)
-	if err != nil {
-		if s != nil {
-			s.Stop()
+	// Split output is written into a directory instead of a single file
+	splitOutput := opts.splitBy != "" && !opts.summary
+
+	output := os.Stdout
+	if !splitOutput {
+		output, err = createOutputFile(opts.outputFile)
+		if err != nil {
+			if s != nil {
+				s.Stop()
+			}
+			fatalError("Error creating output file: %v", err)
 		}
-		fatalError("Error creating output file: %v", err)
+		defer output.Close()
 	}
-	defer output.Close()
 
 	if opts.summary {
-		rowCount, uniqueQuerySequences, uniqueTargetSequences, multiMappedQueries, duplicateCount, err := summarizeUC(input, opts.inputFile, opts)
+		rowCount, uniqueQuerySequences, uniqueTargetSequences, multiMappedQueries, duplicateCount, unmatchedQueries, err := summarizeUC(input, opts.inputFile, opts)
 		if err != nil {
 			if s != nil {
 				s.Stop()
@@ -195,18 +338,43 @@ func main() {
 		if s != nil {
 			s.Stop()
 		}
-		err = writeSummary(output, rowCount, uniqueQuerySequences, uniqueTargetSequences, multiMappedQueries, duplicateCount)
+		err = writeSummary(output, rowCount, uniqueQuerySequences, uniqueTargetSequences, multiMappedQueries, duplicateCount, unmatchedQueries)
+	} else if splitOutput {
+		err = processAndWriteSplit(input, opts, s)
 	} else {
 		writer := bufio.NewWriter(output)
 		defer writer.Flush()
 
-		if strings.HasSuffix(opts.outputFile, ".parquet") {
+		switch format := outputFormat(opts); format {
+		case "parquet":
 			err = processAndWriteParquet(input, opts.outputFile, opts, s)
-		} else {
+		case "uc":
+			// UC output requires all record fields
+			opts.mapOnly = false
+			err = processAndWriteUC(input, writer, opts, s)
+		case "sqlite":
+			// Cluster table requires all record fields
+			opts.mapOnly = false
+			err = processAndWriteSQLite(input, opts.outputFile, opts, s)
+		case "csv":
+			err = processAndWriteCSV(input, writer, opts, s)
+		case "jsonl":
+			err = processAndWriteJSON(input, writer, opts, s)
+		case "arrow":
+			err = processAndWriteArrow(input, writer, opts, s, false)
+		case "arrow-stream":
+			err = processAndWriteArrow(input, writer, opts, s, true)
+		case "tsv":
 			err = processAndWriteText(input, writer, opts, s)
+		default:
+			err = newUCError("Argument", fmt.Sprintf("unknown output format %q", format), nil)
 		}
 	}
 
+	if err == nil && opts.unmatched != nil {
+		err = writeUnmatched(opts.unmatched, opts)
+	}
+
 	if err != nil {
 		if s != nil {
 			s.Stop()
@@ -221,46 +389,108 @@ func main() {
 	}
 }
 
+// Command-line flag with long and short forms
+type flagPair struct {
+	long, short string
+	value       interface{}
+	usage       string
+	def         interface{}
+}
+
+// Register all flags of a flag set
+func registerFlags(fs *flag.FlagSet, flagPairs []flagPair) {
+	for _, f := range flagPairs {
+		switch v := f.value.(type) {
+		case *string:
+			fs.StringVar(v, f.long, f.def.(string), f.usage)
+			if f.short != "" {
+				fs.StringVar(v, f.short, f.def.(string), f.usage)
+			}
+		case *bool:
+			fs.BoolVar(v, f.long, f.def.(bool), f.usage)
+			if f.short != "" {
+				fs.BoolVar(v, f.short, f.def.(bool), f.usage)
+			}
+		case *int:
+			fs.IntVar(v, f.long, f.def.(int), f.usage)
+			if f.short != "" {
+				fs.IntVar(v, f.short, f.def.(int), f.usage)
+			}
+		case *float64:
+			fs.Float64Var(v, f.long, f.def.(float64), f.usage)
+			if f.short != "" {
+				fs.Float64Var(v, f.short, f.def.(float64), f.usage)
+			}
+		}
+	}
+}
+
+// Print flag descriptions with consistent padding
+func printFlags(fs *flag.FlagSet, flagPairs []flagPair) {
+	// Find the longest flag combination to determine padding
+	maxLen := 0
+	for _, f := range flagPairs {
+		flagLen := len(f.long) + 2 // --flag
+		if f.short != "" {
+			flagLen += 4 // -x,
+		}
+		if flagLen > maxLen {
+			maxLen = flagLen
+		}
+	}
+
+	// Format string with consistent padding
+	format := fmt.Sprintf("  %%-%ds\t%%s\n", maxLen)
+
+	for _, f := range flagPairs {
+		shortFlag := ""
+		if f.short != "" {
+			shortFlag = fmt.Sprintf("-%s, ", f.short)
+		}
+		flagName := fmt.Sprintf("%s--%s", shortFlag, f.long)
+		fmt.Fprintf(fs.Output(), format, flagName, f.usage)
+	}
+}
+
 // Parse command line flags
 func parseFlags() Options {
 	opts := Options{}
 
 	// Define flag pairs with long and short forms
-	flagPairs := []struct {
-		long, short string
-		value       interface{}
-		usage       string
-		def         interface{}
-	}{
-		{"input", "i", &opts.inputFile, "Input file (default: stdin)", "-"},
+	flagPairs := []flagPair{
+		{"input", "i", &opts.inputFile, "Input file, UC or Parquet (default: stdin)", "-"},
 		{"output", "o", &opts.outputFile, "Output file (default: stdout)", "-"},
+		{"format", "f", &opts.format, "Output format: tsv, csv, parquet, uc, arrow, arrow-stream, sqlite, jsonl (default: from output file extension)", ""},
+		{"mode", "", &opts.mode, "UC file from: search, cluster, derep (default: auto-detect)", modeAuto},
+		{"columns", "c", &opts.columns, "Comma-separated list of output columns, e.g. query,target,identity", ""},
+		{"delimiter", "", &opts.delimiter, "Field delimiter for CSV output, e.g. ',', '\\t' or '|'", ","},
+		{"no-header", "", &opts.noHeader, "Do not write a header line to text output", false},
+		{"json-fields", "", &opts.jsonFields, "Comma-separated list of fields for JSON output (default: all)", ""},
+		{"relabel", "r", &opts.relabel, "Relabel centroids by: cluster (number), abundance (rank), hash (SHA1 of sequence)", ""},
+		{"relabel-prefix", "", &opts.relabelPrefix, "Prefix of new centroid labels (default: OTU_)", defaultRelabelPrefix},
+		{"relabel-table", "", &opts.relabelTable, "Output file for the old-to-new label table (default: <output>.labels.tsv)", ""},
+		{"seqs", "q", &opts.seqFile, "Centroid sequences for --relabel hash, FASTA or FASTQ", ""},
+		{"taxonomy", "T", &opts.taxonomyFile, "Centroid taxonomy, SINTAX output or two-column TSV (ID, taxonomy)", ""},
+		{"tax-cutoff", "", &opts.taxCutoff, "Minimum confidence of taxonomic ranks (default: 0, no filtering)", 0.0},
+		{"sample-map", "", &opts.sampleMapFile, "TSV of read ID (exact, prefix*, or /regex/) and sample", ""},
+		{"unmatched", "", &opts.unmatchedFile, "Write unmatched queries (N records) into a separate file, as a list or FASTA/FASTQ with --unmatched-seqs", ""},
+		{"unmatched-seqs", "", &opts.querySeqFile, "Query sequences for --unmatched, FASTA or FASTQ", ""},
+		{"split-by", "", &opts.splitBy, "Write one output file per sample, target or cluster into the output directory", ""},
+		{"max-open", "", &opts.maxOpen, "Maximum number of simultaneously open files with --split-by", defaultMaxOpenFiles},
 		{"summary", "s", &opts.summary, "Print summary statistics", false},
 		{"map-only", "m", &opts.mapOnly, "Output only Query-OTU mapping", true},
 		{"split-id", "S", &opts.splitSeqID, "Split sequence IDs at semicolon (default: true)", true},
 		{"rm-dups", "d", &opts.removeDups, "Remove duplicate Query-Target pairs (default: true)", true},
 		{"multi-mapped", "M", &opts.multiMapped, "Output only queries mapped to multiple targets", false},
+		{"resolve", "", &opts.resolve, "Resolve queries with multiple hits: best, random, fractional, ambiguous", ""},
+		{"tie-break", "", &opts.tieBreak, "Tie-break of best hits with equal identity: order, abundance", "order"},
+		{"seed", "", &opts.seed, "Random seed for --resolve random", 1},
 		{"version", "v", &opts.version, "Print version information", false},
 	}
+	// Input format flags follow the output format
+	flagPairs = slices.Insert(flagPairs, 3, inputFlags(&opts.inputOptions)...)
 
-	// Register all flags using a helper function
-	registerFlag := func(long, short string, value interface{}, usage string, def interface{}) {
-		switch v := value.(type) {
-		case *string:
-			flag.StringVar(v, long, def.(string), usage)
-			if short != "" {
-				flag.StringVar(v, short, def.(string), usage)
-			}
-		case *bool:
-			flag.BoolVar(v, long, def.(bool), usage)
-			if short != "" {
-				flag.BoolVar(v, short, def.(bool), usage)
-			}
-		}
-	}
-
-	for _, f := range flagPairs {
-		registerFlag(f.long, f.short, f.value, f.usage, f.def)
-	}
+	registerFlags(flag.CommandLine, flagPairs)
 
 	// Custom usage message
 	flag.Usage = func() {
@@ -268,32 +498,15 @@ func parseFlags() Options {
 
 Usage:
   ucs -i <input.uc.gz> -o <output>
+  ucs <command> [flags]
 
-Flags:
+Commands:
 `, Version)
-		// Find the longest flag combination to determine padding
-		maxLen := 0
-		for _, f := range flagPairs {
-			flagLen := len(f.long) + 2 // --flag
-			if f.short != "" {
-				flagLen += 4 // -x,
-			}
-			if flagLen > maxLen {
-				maxLen = flagLen
-			}
-		}
-
-		// Format string with consistent padding
-		format := fmt.Sprintf("  %%-%ds\t%%s\n", maxLen)
-
-		for _, f := range flagPairs {
-			shortFlag := ""
-			if f.short != "" {
-				shortFlag = fmt.Sprintf("-%s, ", f.short)
-			}
-			flagName := fmt.Sprintf("%s--%s", shortFlag, f.long)
-			fmt.Fprintf(flag.CommandLine.Output(), format, flagName, f.usage)
+		for _, c := range commands {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-10s\t%s\n", c.name, c.usage)
 		}
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+		printFlags(flag.CommandLine, flagPairs)
 		fmt.Fprintf(flag.CommandLine.Output(), "\nFor more information, visit https://github.com/vmikk/ucs\n")
 	}
 
@@ -305,6 +518,11 @@ Flags:
 		os.Exit(0)
 	}
 
+	// Selected columns may need more than Query and Target
+	if opts.columns != "" {
+		opts.mapOnly = false
+	}
+
 	// Auto-detect stdin if no input file specified and stdin is a pipe
 	if opts.inputFile == "-" && !isTerminal(os.Stdin) {
 		opts.inputFile = "-"
@@ -320,6 +538,30 @@ Flags:
 	return opts
 }
 
+// Determine output format from the --format option or output file extension
+func outputFormat(opts Options) string {
+	if opts.format != "" {
+		return strings.ToLower(opts.format)
+	}
+	switch {
+	case strings.HasSuffix(opts.outputFile, ".parquet"):
+		return "parquet"
+	case strings.HasSuffix(opts.outputFile, ".uc"):
+		return "uc"
+	case strings.HasSuffix(opts.outputFile, ".arrow"), strings.HasSuffix(opts.outputFile, ".feather"):
+		return "arrow"
+	case strings.HasSuffix(opts.outputFile, ".arrows"):
+		return "arrow-stream"
+	case strings.HasSuffix(opts.outputFile, ".sqlite"), strings.HasSuffix(opts.outputFile, ".db"):
+		return "sqlite"
+	case strings.HasSuffix(opts.outputFile, ".jsonl"), strings.HasSuffix(opts.outputFile, ".ndjson"):
+		return "jsonl"
+	case strings.HasSuffix(opts.outputFile, ".csv"):
+		return "csv"
+	}
+	return "tsv"
+}
+
 func openInputFile(fileName string) (*os.File, error) {
 	if fileName == "-" {
 		return os.Stdin, nil
@@ -361,9 +603,11 @@ func parseUCRecord(line string, opts Options) (UCRecord, bool) {
 	targetLabel := splitSeqID(fields[9], opts.splitSeqID)
 
 	record := UCRecord{
-		RecordType: fields[0],
-		Query:      queryLabel,
-		Target:     targetLabel,
+		RecordType:  fields[0],
+		Query:       queryLabel,
+		Target:      targetLabel,
+		QueryLabel:  fields[8],
+		TargetLabel: fields[9],
 	}
 
 	// Process target based on record type
@@ -382,6 +626,7 @@ func parseUCRecord(line string, opts Options) (UCRecord, bool) {
 		if fields[3] != "*" {
 			if val, err := strconv.ParseFloat(fields[3], 64); err == nil {
 				record.Identity = &val
+				record.IdentityText = fields[3]
 			}
 		}
 		if fields[4] != "*" {
@@ -391,6 +636,7 @@ func parseUCRecord(line string, opts Options) (UCRecord, bool) {
 	case "S":
 		// Seed record - use query as both query and target
 		record.Target = queryLabel
+		record.TargetLabel = fields[8]
 		// Parse cluster number and size for S records
 		if num, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
 			record.ClusterNumber = uint32(num)
@@ -402,6 +648,7 @@ func parseUCRecord(line string, opts Options) (UCRecord, bool) {
 	case "N":
 		// No hit - use query as target
 		record.Target = queryLabel
+		record.TargetLabel = fields[8]
 		// For N records, cluster and size should be parsed
 		if num, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
 			record.ClusterNumber = uint32(num)
@@ -435,84 +682,174 @@ func parseMapRecord(line string, split bool) (UCRecord, bool) {
 	target := splitSeqID(fields[9], split)
 
 	// Handle special cases based on record type
+	targetLabel := fields[9]
 	switch fields[0] {
 	case "S", "N":
 		target = query
+		targetLabel = fields[8]
 	}
 
 	return UCRecord{
-		RecordType: fields[0],
-		Query:      query,
-		Target:     target,
+		RecordType:  fields[0],
+		Query:       query,
+		Target:      target,
+		QueryLabel:  fields[8],
+		TargetLabel: targetLabel,
 	}, true
 }
 
+// Sequential source of UC records
+type RecordReader interface {
+	// Next returns the next record, or io.EOF when the input is exhausted
+	Next() (UCRecord, error)
+	// Rows returns the number of input rows consumed so far
+	Rows() int
+}
+
+// Record reader for UC text input
+type ucTextReader struct {
+	scanner *bufio.Scanner
+	opts    Options
+	rows    int
+}
+
+func (r *ucTextReader) Next() (UCRecord, error) {
+	for r.scanner.Scan() {
+		r.rows++
+
+		var record UCRecord
+		var ok bool
+		// Resolving hits needs identities, which are only parsed for full records
+		if r.opts.mapOnly && r.opts.resolve == "" {
+			// Optimized path for map-only mode
+			record, ok = parseMapRecord(r.scanner.Text(), r.opts.splitSeqID)
+		} else {
+			// Original path for full record processing
+			record, ok = parseUCRecord(r.scanner.Text(), r.opts)
+		}
+		if ok {
+			return record, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return UCRecord{}, err
+	}
+	return UCRecord{}, io.EOF
+}
+
+func (r *ucTextReader) Rows() int {
+	return r.rows
+}
+
 // UC-file processing logic
-func processRecords(scanner *bufio.Scanner, opts Options, handler func(UCRecord) error, s *spinner.Spinner) error {
+func processRecords(reader RecordReader, opts Options, handler func(UCRecord) error, s *spinner.Spinner) error {
 	seenPairs := make(map[string]struct{})
 	queryToTargets := make(map[string]map[string]struct{})
 	duplicateCount := 0
-	lineNum := 0
+	unmatchedCount := 0
+	mode := fixedMode(opts.mode)
+
+	var resolver *hitResolver
+	if opts.resolve != "" {
+		resolver = newHitResolver(opts)
+	}
 
-	for scanner.Scan() {
-		lineNum++
+	// Pass a record on to the sample, relabel, deduplication and resolution steps
+	emit := func(record UCRecord) error {
+		// Assign the query to a sample
+		if opts.sampleMap != nil {
+			record.Sample, _ = opts.sampleMap.lookup(record.Query)
+		}
 
-		var err error
-		if opts.mapOnly {
-			// Optimized path for map-only mode
-			record, ok := parseMapRecord(scanner.Text(), opts.splitSeqID)
-			if !ok {
-				continue
+		// Rename centroids
+		if opts.relabelMap != nil {
+			if label, ok := opts.relabelMap[record.Target]; ok {
+				record.TargetLabel = relabelLabel(record.TargetLabel, record.Target, label)
+				record.Target = label
 			}
+		}
 
-			if opts.removeDups {
-				pairKey := record.Query + "\t" + record.Target
-				if _, exists := seenPairs[pairKey]; exists {
-					duplicateCount++
-					continue
-				}
-				seenPairs[pairKey] = struct{}{}
+		if opts.removeDups {
+			pairKey := record.Query + "\t" + record.Target
+			if _, exists := seenPairs[pairKey]; exists {
+				duplicateCount++
+				return nil
 			}
+			seenPairs[pairKey] = struct{}{}
+		}
 
-			if opts.multiMapped {
-				if _, exists := queryToTargets[record.Query]; !exists {
-					queryToTargets[record.Query] = make(map[string]struct{})
-				}
-				queryToTargets[record.Query][record.Target] = struct{}{}
-			} else {
-				err = handler(record)
-			}
-		} else {
-			// Original path for full record processing
-			record, ok := parseUCRecord(scanner.Text(), opts)
-			if !ok {
-				continue
+		if opts.multiMapped {
+			if _, exists := queryToTargets[record.Query]; !exists {
+				queryToTargets[record.Query] = make(map[string]struct{})
 			}
+			queryToTargets[record.Query][record.Target] = struct{}{}
+			return nil
+		}
+
+		if resolver != nil {
+			resolver.add(record)
+			return nil
+		}
 
-			if opts.removeDups {
-				pairKey := record.Query + "\t" + record.Target
-				if _, exists := seenPairs[pairKey]; exists {
-					duplicateCount++
-					continue
+		if err := handler(record); err != nil {
+			return newUCError("IO", fmt.Sprintf("failed to write record at line %d", reader.Rows()), err)
+		}
+		return nil
+	}
+
+	// Count (and collect) an unmatched query
+	skip := func(record UCRecord) {
+		unmatchedCount++
+		if opts.unmatched != nil {
+			opts.unmatched.add(record.Query)
+		}
+	}
+
+	// N records of undetected files wait until the mode is known
+	var held []UCRecord
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// S records only occur in clusterings; N records seen
+		// before the first one are self-mappings after all
+		if mode == "" && record.RecordType == "S" {
+			mode = modeCluster
+			for _, h := range held {
+				if err := emit(h); err != nil {
+					return err
 				}
-				seenPairs[pairKey] = struct{}{}
 			}
+			held = nil
+		}
 
-			if opts.multiMapped {
-				if _, exists := queryToTargets[record.Query]; !exists {
-					queryToTargets[record.Query] = make(map[string]struct{})
-				}
-				queryToTargets[record.Query][record.Target] = struct{}{}
-			} else {
-				err = handler(record)
+		// In searches, N records are unmatched queries rather than self-mappings
+		if record.RecordType == "N" {
+			switch {
+			case opts.unmatched != nil || (mode == modeSearch && !opts.keepN):
+				skip(record)
+				continue
+			case mode == "" && !opts.keepN:
+				held = append(held, record)
+				continue
 			}
 		}
 
-		if err != nil {
-			return newUCError("IO", fmt.Sprintf("failed to write record at line %d", lineNum), err)
+		if err := emit(record); err != nil {
+			return err
 		}
 	}
 
+	// Files without S records are searches
+	for _, h := range held {
+		skip(h)
+	}
+
 	// Handle multi-mapped queries if needed
 	if opts.multiMapped {
 		for query, targets := range queryToTargets {
@@ -526,58 +863,147 @@ func processRecords(scanner *bufio.Scanner, opts Options, handler func(UCRecord)
 		}
 	}
 
-	if duplicateCount > 0 {
-		if s != nil {
-			s.Stop() // Stop spinner before showing warning
-			fmt.Fprintf(os.Stderr, "\033[31mucs: removed %d duplicate entries\033[0m\n", duplicateCount)
-			s.Start() // Restart spinner for remaining processing
-		} else {
-			fmt.Fprintf(os.Stderr, "\033[31mucs: removed %d duplicate entries\033[0m\n", duplicateCount)
+	// Resolve queries with multiple hits
+	if resolver != nil {
+		affected, err := resolver.flush(handler)
+		if err != nil {
+			return err
+		}
+		if affected > 0 && !opts.quiet {
+			printWarning(s, "resolved %d multi-mapped queries (--resolve %s)", affected, opts.resolve)
 		}
 	}
 
-	return scanner.Err()
+	if duplicateCount > 0 && !opts.quiet {
+		printWarning(s, "removed %d duplicate entries", duplicateCount)
+	}
+	if unmatchedCount > 0 && !opts.quiet {
+		printWarning(s, "skipped %d unmatched queries", unmatchedCount)
+	}
+
+	return nil
+}
+
+// Print a warning to stderr, pausing the spinner
+func printWarning(s *spinner.Spinner, format string, args ...any) {
+	if s != nil {
+		s.Stop()        // Stop spinner before showing warning
+		defer s.Start() // Restart spinner for remaining processing
+	}
+	fmt.Fprintf(os.Stderr, "\033[31mucs: "+format+"\033[0m\n", args...)
+}
+
+// Header line and selected columns (nil for the default layout) of TSV output
+func textHeader(opts Options) (string, []ucColumn, error) {
+	columns, err := parseColumns(opts.columns, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	header := strings.Join(columnHeader(columns, opts), "\t") + "\n"
+
+	if !customColumns(opts) {
+		return header, nil, nil
+	}
+	return header, columns, nil
 }
 
 // Process UC-file and write output into TSV format
 func processAndWriteText(input *os.File, writer *bufio.Writer, opts Options, s *spinner.Spinner) error {
-	scanner, err := createScanner(input, opts.inputFile)
+	reader, err := openRecordReader(input, opts.inputFile, opts)
 	if err != nil {
-		return newUCError("IO", "failed to create scanner", err)
+		return newUCError("IO", "failed to open input", err)
 	}
 
-	// Write header
-	header := "Query\tTarget\n"
-	if !opts.mapOnly {
-		header = "recordType\tclusterNumber\tsize\tidentity\tstrand\tunused1\tunused2\tcigar\tquery\ttarget\n"
+	header, columns, err := textHeader(opts)
+	if err != nil {
+		return err
 	}
-	if _, err := writer.WriteString(header); err != nil {
-		return newUCError("IO", "failed to write header", err)
+	if !opts.noHeader {
+		if _, err := writer.WriteString(header); err != nil {
+			return newUCError("IO", "failed to write header", err)
+		}
 	}
 
-	return processRecords(scanner, opts, func(record UCRecord) error {
-		return writeUCRecord(writer, record, opts)
+	return processRecords(reader, opts, func(record UCRecord) error {
+		return writeUCRecord(writer, record, opts, columns)
 	}, s)
 }
 
+// Parquet batching parameters
+const (
+	parquetBatchSize    = 8192       // Records buffered before each Write call
+	parquetRowGroupSize = 128 * 1024 // Maximum number of rows per row group
+)
+
+// Buffer of records written to Parquet in batches
+type parquetBatch[T any] struct {
+	writer *parquet.GenericWriter[T]
+	buf    []T
+}
+
+func newParquetBatch[T any](writer *parquet.GenericWriter[T], size int) *parquetBatch[T] {
+	return &parquetBatch[T]{
+		writer: writer,
+		buf:    make([]T, 0, size),
+	}
+}
+
+// Add a record to the batch, writing the batch out once it is full
+func (b *parquetBatch[T]) Add(record T) error {
+	b.buf = append(b.buf, record)
+	if len(b.buf) == cap(b.buf) {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Write buffered records and reuse the buffer for the next batch
+func (b *parquetBatch[T]) Flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	_, err := b.writer.Write(b.buf)
+	clear(b.buf)
+	b.buf = b.buf[:0]
+	return err
+}
+
 // Process UC-file and write output into Parquet format
 func processAndWriteParquet(input *os.File, outputFile string, opts Options, s *spinner.Spinner) error {
-	f, err := os.Create(outputFile)
+	f, err := createOutputFile(outputFile)
 	if err != nil {
 		return newUCError("IO", "failed to create output file", err)
 	}
 	defer f.Close()
 
-	scanner, err := createScanner(input, opts.inputFile)
+	reader, err := openRecordReader(input, opts.inputFile, opts)
 	if err != nil {
-		return newUCError("IO", "failed to create scanner", err)
+		return newUCError("IO", "failed to open input", err)
 	}
 
+	return writeParquet(f, reader, opts, s)
+}
+
+// Options of all Parquet writers
+func parquetWriterOptions() []parquet.WriterOption {
 	// Configure ZSTD codec with better compression
 	zstdCodec := &zstd.Codec{Level: zstd.SpeedBetterCompression}
+	return []parquet.WriterOption{
+		parquet.Compression(zstdCodec),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	}
+}
+
+// Write records into Parquet format
+func writeParquet(f io.Writer, reader RecordReader, opts Options, s *spinner.Spinner) error {
+	writerOpts := parquetWriterOptions()
+
+	if customColumns(opts) {
+		return writeParquetColumns(f, reader, opts, writerOpts, s)
+	}
 
 	if opts.mapOnly {
-		writer := parquet.NewGenericWriter[MapRecord](f, parquet.Compression(zstdCodec))
+		writer := parquet.NewGenericWriter[MapRecord](f, writerOpts...)
 		defer func() {
 			if err := writer.Close(); err != nil {
 				// Log the error since we can't return it from the defer
@@ -585,27 +1011,85 @@ func processAndWriteParquet(input *os.File, outputFile string, opts Options, s *
 			}
 		}()
 
-		return processRecords(scanner, opts, func(record UCRecord) error {
-			_, err := writer.Write([]MapRecord{{Query: record.Query, Target: record.Target}})
-			return err
+		batch := newParquetBatch(writer, parquetBatchSize)
+		err := processRecords(reader, opts, func(record UCRecord) error {
+			return batch.Add(MapRecord{Query: record.Query, Target: record.Target})
 		}, s)
+		if err != nil {
+			return err
+		}
+		return batch.Flush()
 	}
 
-	writer := parquet.NewGenericWriter[ParquetRecord](f, parquet.Compression(zstdCodec))
+	writer := parquet.NewGenericWriter[ParquetRecord](f, writerOpts...)
 	defer func() {
 		if err := writer.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "\033[31mError closing parquet writer: %v\033[0m\n", err)
 		}
 	}()
 
-	return processRecords(scanner, opts, func(record UCRecord) error {
-		_, err := writer.Write([]ParquetRecord{record.ToParquet()})
+	batch := newParquetBatch(writer, parquetBatchSize)
+	err := processRecords(reader, opts, func(record UCRecord) error {
+		return batch.Add(record.ToParquet())
+	}, s)
+	if err != nil {
+		return err
+	}
+	return batch.Flush()
+}
+
+// Write Parquet output with a schema generated from the selected columns
+func writeParquetColumns(f io.Writer, reader RecordReader, opts Options, writerOpts []parquet.WriterOption, s *spinner.Spinner) error {
+	columns, err := parseColumns(opts.columns, opts)
+	if err != nil {
+		return err
+	}
+
+	schema := parquetSchema(columns)
+	writer := parquet.NewGenericWriter[any](f, append(writerOpts, schema)...)
+
+	// Rows are buffered and written in batches, reusing the row buffers
+	rows := make([]parquet.Row, 0, parquetBatchSize)
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		_, err := writer.WriteRows(rows)
+		rows = rows[:0]
 		return err
+	}
+
+	err = processRecords(reader, opts, func(record UCRecord) error {
+		n := len(rows)
+		rows = rows[:n+1]
+		rows[n] = parquetRow(rows[n], record, columns)
+		if len(rows) == cap(rows) {
+			return flush()
+		}
+		return nil
 	}, s)
+	if err == nil {
+		err = flush()
+	}
+
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = newUCError("IO", "failed to close parquet writer", closeErr)
+	}
+	return err
 }
 
 // Helper function to write a single record
-func writeUCRecord(writer *bufio.Writer, record UCRecord, opts Options) error {
+func writeUCRecord(writer *bufio.Writer, record UCRecord, opts Options, columns []ucColumn) error {
+	if columns != nil {
+		for i, c := range columns {
+			if i > 0 {
+				writer.WriteByte('\t')
+			}
+			writer.WriteString(c.text(record))
+		}
+		return writer.WriteByte('\n')
+	}
+
 	if opts.mapOnly {
 		_, err := fmt.Fprintf(writer, "%s\t%s\n", record.Query, record.Target)
 		return err
@@ -629,43 +1113,45 @@ func writeUCRecord(writer *bufio.Writer, record UCRecord, opts Options) error {
 }
 
 // UC file summary
-func summarizeUC(input *os.File, inputFileName string, opts Options) (int, int, int, int, int, error) {
-	scanner, err := createScanner(input, inputFileName)
+func summarizeUC(input *os.File, inputFileName string, opts Options) (int, int, int, int, int, int, error) {
+	// Only Query and Target fields are needed for the summary
+	opts.mapOnly = true
+	reader, err := openRecordReader(input, inputFileName, opts)
 	if err != nil {
-		return 0, 0, 0, 0, 0, err
+		return 0, 0, 0, 0, 0, 0, err
 	}
 
-	rowCount := 0
 	querySequences := make(map[string]struct{})            // Set of unique queries
 	targetSequences := make(map[string]struct{})           // Set of unique targets
 	queryToTargets := make(map[string]map[string]struct{}) // Unique query to target pairs
 	seenPairs := make(map[string]struct{})                 // Set to track duplicates
 	duplicateCount := 0                                    // Number of duplicate query-target pairs
+	unmatched := make(map[string]struct{})                 // Unique queries in N records
+	clustered := false                                     // Whether an S record was seen
 
-	for scanner.Scan() {
-		rowCount++ // Count every line
-		line := scanner.Text()
-		fields := strings.Split(line, "\t")
-
-		// Skip broken lines
-		if len(fields) < 10 {
-			continue
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("reading input: %w", err)
 		}
 
-		// Skip C records for sequence analysis
-		if fields[0] == "C" {
+		// N records have no target; they are counted once the mode is known
+		if record.RecordType == "N" {
+			unmatched[record.Query] = struct{}{}
+			if opts.unmatched != nil {
+				opts.unmatched.add(record.Query)
+			}
 			continue
 		}
-
-		// Process all valid non-C records
-		queryLabel := splitSeqID(fields[8], opts.splitSeqID)
-		targetLabel := splitSeqID(fields[9], opts.splitSeqID)
-		if fields[0] == "S" {
-			targetLabel = queryLabel
+		if record.RecordType == "S" {
+			clustered = true
 		}
 
 		// Check for duplicates
-		pairKey := queryLabel + "\t" + targetLabel
+		pairKey := record.Query + "\t" + record.Target
 		if _, exists := seenPairs[pairKey]; exists {
 			duplicateCount++
 			continue
@@ -673,15 +1159,37 @@ func summarizeUC(input *os.File, inputFileName string, opts Options) (int, int,
 		seenPairs[pairKey] = struct{}{}
 
 		// Add query to the set of unique queries
-		querySequences[queryLabel] = struct{}{}
-
-		if _, exists := queryToTargets[queryLabel]; !exists {
-			queryToTargets[queryLabel] = make(map[string]struct{})
+		querySequences[record.Query] = struct{}{}
+		targetSequences[record.Target] = struct{}{}
+		if _, exists := queryToTargets[record.Query]; !exists {
+			queryToTargets[record.Query] = make(map[string]struct{})
 		}
+		queryToTargets[record.Query][record.Target] = struct{}{}
+	}
 
-		if targetLabel != "*" {
-			targetSequences[targetLabel] = struct{}{}
-			queryToTargets[queryLabel][targetLabel] = struct{}{}
+	// Unmatched queries of searches (or with --unmatched) are not mapped;
+	// in clusterings they are mapped to themselves
+	unmatchedQueries := 0
+	if isSearch(opts.mode, clustered) || opts.unmatchedFile != "" {
+		for query := range unmatched {
+			if _, mapped := querySequences[query]; !mapped {
+				unmatchedQueries++
+			}
+		}
+	} else {
+		for query := range unmatched {
+			pairKey := query + "\t" + query
+			if _, exists := seenPairs[pairKey]; exists {
+				duplicateCount++
+				continue
+			}
+			seenPairs[pairKey] = struct{}{}
+			querySequences[query] = struct{}{}
+			targetSequences[query] = struct{}{}
+			if _, exists := queryToTargets[query]; !exists {
+				queryToTargets[query] = make(map[string]struct{})
+			}
+			queryToTargets[query][query] = struct{}{}
 		}
 	}
 
@@ -693,14 +1201,13 @@ func summarizeUC(input *os.File, inputFileName string, opts Options) (int, int,
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return 0, 0, 0, 0, 0, fmt.Errorf("reading input: %w", err)
-	}
+	// Every input row is counted, including C records and broken lines
+	rowCount := reader.Rows()
 
-	return rowCount, len(querySequences), len(targetSequences), duplicateCount, multiMappedQueries, nil
+	return rowCount, len(querySequences), len(targetSequences), duplicateCount, multiMappedQueries, unmatchedQueries, nil
 }
 
-func writeSummary(output *os.File, rowCount, uniqueQuerySequences, uniqueTargetSequences, duplicateCount, multiMappedQueries int) error {
+func writeSummary(output *os.File, rowCount, uniqueQuerySequences, uniqueTargetSequences, duplicateCount, multiMappedQueries, unmatchedQueries int) error {
 	// Check if output is stdout
 	useColors := output != os.Stdout
 
@@ -715,6 +1222,7 @@ func writeSummary(output *os.File, rowCount, uniqueQuerySequences, uniqueTargetS
 		{"Unique target sequences:", uniqueTargetSequences, false},
 		{"Duplicate query-target pairs:", duplicateCount, true},
 		{"Queries mapped to multiple targets:", multiMappedQueries, true},
+		{"Unmatched queries:", unmatchedQueries, false},
 	}
 
 	// Find the longest label and the longest number
@@ -748,14 +1256,71 @@ func writeSummary(output *os.File, rowCount, uniqueQuerySequences, uniqueTargetS
 	return err
 }
 
+// Open a record reader for UC text or Parquet input
+func openRecordReader(input *os.File, inputFileName string, opts Options) (RecordReader, error) {
+	if strings.HasSuffix(inputFileName, ".parquet") {
+		return newParquetFileReader(input, opts)
+	}
+
+	// Check for Parquet magic bytes at the start of the input
+	reader := bufio.NewReader(input)
+	if magic, err := reader.Peek(4); err == nil && string(magic) == parquetMagic {
+		if inputFileName != "-" {
+			return newParquetFileReader(input, opts)
+		}
+		// Parquet requires random access, so stdin is read into memory
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading parquet input: %w", err)
+		}
+		return newParquetRecordReader(bytes.NewReader(data), int64(len(data)), opts)
+	}
+
+	text, err := decompress(reader, inputFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tabular search output (BLAST6 or --userout) instead of UC;
+	// the first line is peeked, so the buffer holds the longest line the scanner accepts
+	text = bufio.NewReaderSize(text, bufio.MaxScanTokenSize)
+	switch detectInputFormat(text, inputFileName, opts) {
+	case inputBlast6:
+		return &tabularReader{scanner: bufio.NewScanner(text), parse: func(line string) (UCRecord, bool) {
+			return parseBlast6Record(line, opts.splitSeqID)
+		}}, nil
+	case inputUserout:
+		fields, err := parseUserFields(opts.userFields)
+		if err != nil {
+			return nil, err
+		}
+		return &tabularReader{scanner: bufio.NewScanner(text), parse: func(line string) (UCRecord, bool) {
+			return fields.parse(line, opts.splitSeqID)
+		}}, nil
+	}
+	return &ucTextReader{scanner: bufio.NewScanner(text), opts: opts}, nil
+}
+
 // Buffered scanner for input file
 func createScanner(input *os.File, inputFileName string) (*bufio.Scanner, error) {
-	reader := bufio.NewReader(input)
+	return newScanner(bufio.NewReader(input), inputFileName)
+}
+
+// Scanner over buffered input, decompressing gzipped data
+func newScanner(reader *bufio.Reader, inputFileName string) (*bufio.Scanner, error) {
+	text, err := decompress(reader, inputFileName)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewScanner(text), nil
+}
 
+// Buffered reader over the input, decompressing gzipped data
+func decompress(reader *bufio.Reader, inputFileName string) (*bufio.Reader, error) {
 	// Check if input is gzipped, either by filename or content
 	isGzipped := strings.HasSuffix(inputFileName, ".gz")
-	if !isGzipped && inputFileName == "-" {
-		// For stdin, peek at the first two bytes to check for gzip magic number
+	if !isGzipped {
+		// Peek at the first two bytes to check for gzip magic number
 		magic, err := reader.Peek(2)
 		if err == nil && len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
 			isGzipped = true
@@ -767,8 +1332,8 @@ func createScanner(input *os.File, inputFileName string) (*bufio.Scanner, error)
 		if err != nil {
 			return nil, fmt.Errorf("creating gzip reader: %w", err)
 		}
-		return bufio.NewScanner(gzipReader), nil
+		return bufio.NewReader(gzipReader), nil
 	}
 
-	return bufio.NewScanner(reader), nil
+	return reader, nil
 }
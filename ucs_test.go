@@ -2,14 +2,29 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	// "compress/gzip"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gmeasure"
 	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
 )
 
 var _ = Describe("UCS", func() {
@@ -45,7 +60,7 @@ var _ = Describe("UCS", func() {
 				multiMapped: false,
 			}
 
-			rowCount, uniqueQueries, uniqueTargets, dups, multiMapped, err := summarizeUC(input, "test/test.uc.gz", opts)
+			rowCount, uniqueQueries, uniqueTargets, dups, multiMapped, unmatched, err := summarizeUC(input, "test/test.uc.gz", opts)
 			Expect(err).NotTo(HaveOccurred())
 
 			// Assert known values
@@ -54,6 +69,7 @@ var _ = Describe("UCS", func() {
 			Expect(uniqueTargets).To(Equal(376))
 			Expect(dups).To(Equal(0))
 			Expect(multiMapped).To(Equal(0))
+			Expect(unmatched).To(Equal(0))
 		})
 	})
 
@@ -167,4 +183,1934 @@ var _ = Describe("UCS", func() {
 			Expect(len(targets)).To(BeNumerically("==", 376))
 		})
 	})
+
+	// ---------- Parquet input ----------
+
+	Context("Parquet input", func() {
+		// Convert the test UC file into Parquet
+		writeParquet := func(outFile string, mapOnly bool) {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  testFile,
+				mapOnly:    mapOnly,
+				splitSeqID: true,
+				removeDups: true,
+			}
+			Expect(processAndWriteParquet(input, outFile, opts, nil)).To(Succeed())
+		}
+
+		It("should summarize full-record Parquet produced by ucs", func() {
+			pqFile := filepath.Join(tmpDir, "full.parquet")
+			writeParquet(pqFile, false)
+
+			input, err := openInputFile(pqFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{splitSeqID: true}
+			_, uniqueQueries, uniqueTargets, dups, multiMapped, _, err := summarizeUC(input, pqFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(uniqueQueries).To(Equal(24953))
+			Expect(uniqueTargets).To(Equal(376))
+			Expect(dups).To(Equal(0))
+			Expect(multiMapped).To(Equal(0))
+		})
+
+		It("should detect Parquet by magic bytes and convert it to TSV", func() {
+			// Use a file name without the .parquet extension
+			pqFile := filepath.Join(tmpDir, "map.bin")
+			writeParquet(pqFile, true)
+
+			input, err := openInputFile(pqFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  pqFile,
+				mapOnly:    true,
+				splitSeqID: true,
+				removeDups: true,
+			}
+
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			Expect(lines[0]).To(Equal("Query\tTarget"))
+			Expect(lines).To(HaveLen(24953 + 1))
+		})
+	})
+
+	// ---------- UC output ----------
+
+	Context("UC output", func() {
+		It("should reproduce the input UC file", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  testFile,
+				splitSeqID: false,
+				removeDups: true,
+			}
+
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteUC(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			// Read the original (uncompressed) UC file
+			orig, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer orig.Close()
+			scanner, err := createScanner(orig, testFile)
+			Expect(err).NotTo(HaveOccurred())
+			var expected strings.Builder
+			for scanner.Scan() {
+				expected.WriteString(scanner.Text() + "\n")
+			}
+			Expect(scanner.Err()).NotTo(HaveOccurred())
+
+			Expect(buf.String()).To(Equal(expected.String()))
+		})
+
+		It("should keep label annotations and N record fields with split IDs", func() {
+			ucData := "S\t0\t4\t*\t*\t*\t*\t*\tseq1;size=5\t*\n" +
+				"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq2;size=2\tseq1;size=5\n" +
+				"N\t*\t*\t*\t*\t*\t*\t*\tseq3;size=1\t*\n" +
+				"C\t0\t2\t*\t*\t*\t*\t*\tseq1;size=5\t*\n"
+			ucFile := filepath.Join(tmpDir, "labels.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: ucFile, splitSeqID: true, removeDups: true}
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteUC(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+			Expect(buf.String()).To(Equal(ucData))
+		})
+
+		It("should keep the N records of searches", func() {
+			ucData := "H\t12\t4\t99.0\t+\t0\t0\t4M\tq1\tt1\n" +
+				"N\t*\t*\t*\t*\t*\t*\t*\tq2\t*\n" +
+				"H\t3\t4\t98.0\t+\t0\t0\t4M\tq3\tt2\n"
+			ucFile := filepath.Join(tmpDir, "search.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			write := func(opts Options) string {
+				input, err := openInputFile(ucFile)
+				Expect(err).NotTo(HaveOccurred())
+				defer input.Close()
+
+				var buf bytes.Buffer
+				writer := bufio.NewWriter(&buf)
+				Expect(processAndWriteUC(input, writer, opts, nil)).To(Succeed())
+				Expect(writer.Flush()).To(Succeed())
+				return buf.String()
+			}
+
+			Expect(write(Options{inputFile: ucFile, splitSeqID: true, removeDups: true})).To(Equal(ucData))
+
+			// Unmatched queries collected by --unmatched are left out
+			opts := Options{inputFile: ucFile, splitSeqID: true, removeDups: true, unmatched: newUnmatchedQueries()}
+			Expect(write(opts)).NotTo(ContainSubstring("q2"))
+			Expect(opts.unmatched.ids).To(Equal([]string{"q2"}))
+		})
+	})
+
+	// ---------- Arrow output ----------
+
+	Context("Arrow output", func() {
+		opts := Options{
+			inputFile:  testFile,
+			mapOnly:    false,
+			splitSeqID: true,
+			removeDups: true,
+		}
+
+		// Count rows and unique targets in a sequence of Arrow record batches
+		countRows := func(next func() bool, record func() arrow.Record) (int, int) {
+			rows := 0
+			targets := make(map[string]struct{})
+			for next() {
+				rec := record()
+				Expect(rec.Schema().Equal(fullArrowSchema)).To(BeTrue())
+				col := rec.Column(9).(*array.String)
+				for i := 0; i < col.Len(); i++ {
+					targets[col.Value(i)] = struct{}{}
+				}
+				rows += int(rec.NumRows())
+			}
+			return rows, len(targets)
+		}
+
+		It("should write an Arrow IPC file", func() {
+			outFile := filepath.Join(tmpDir, "out.arrow")
+
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			output, err := createOutputFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(processAndWriteArrow(input, output, opts, nil, false)).To(Succeed())
+			Expect(output.Close()).To(Succeed())
+
+			f, err := os.Open(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+
+			reader, err := ipc.NewFileReader(f)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			i := -1
+			rows, targets := countRows(
+				func() bool { i++; return i < reader.NumRecords() },
+				func() arrow.Record {
+					rec, err := reader.Record(i)
+					Expect(err).NotTo(HaveOccurred())
+					return rec
+				})
+			Expect(rows).To(Equal(24953))
+			Expect(targets).To(Equal(376))
+		})
+
+		It("should write an Arrow IPC stream", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			var buf bytes.Buffer
+			Expect(processAndWriteArrow(input, &buf, opts, nil, true)).To(Succeed())
+
+			reader, err := ipc.NewReader(&buf)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Release()
+
+			rows, targets := countRows(reader.Next, reader.Record)
+			Expect(reader.Err()).NotTo(HaveOccurred())
+			Expect(rows).To(Equal(24953))
+			Expect(targets).To(Equal(376))
+		})
+	})
+
+	// ---------- SQLite output ----------
+
+	Context("SQLite output", func() {
+		It("should populate records, mappings and clusters tables", func() {
+			outFile := filepath.Join(tmpDir, "out.sqlite")
+
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  testFile,
+				splitSeqID: true,
+				removeDups: true,
+			}
+			Expect(processAndWriteSQLite(input, outFile, opts, nil)).To(Succeed())
+
+			db, err := sql.Open("sqlite", outFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer db.Close()
+
+			count := func(query string) int {
+				var n int
+				Expect(db.QueryRow(query).Scan(&n)).To(Succeed())
+				return n
+			}
+
+			Expect(count("SELECT COUNT(*) FROM records")).To(Equal(24953))
+			Expect(count("SELECT COUNT(DISTINCT query) FROM mappings")).To(Equal(24953))
+			Expect(count("SELECT COUNT(*) FROM clusters")).To(Equal(376))
+			Expect(count("SELECT SUM(size) FROM clusters")).To(Equal(24953))
+			Expect(count("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index'")).To(Equal(3))
+			Expect(count("SELECT COUNT(*) FROM sqlite_master WHERE type = 'view' AND name = 'mappings'")).To(Equal(1))
+		})
+	})
+
+	// ---------- JSON Lines output ----------
+
+	Context("JSON Lines output", func() {
+		writeJSON := func(opts Options) []map[string]any {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteJSON(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			var records []map[string]any
+			scanner := bufio.NewScanner(&buf)
+			for scanner.Scan() {
+				var record map[string]any
+				Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+				records = append(records, record)
+			}
+			return records
+		}
+
+		It("should encode missing identity and strand as null", func() {
+			records := writeJSON(Options{
+				inputFile:  testFile,
+				splitSeqID: true,
+				removeDups: true,
+			})
+			Expect(records).To(HaveLen(24953))
+
+			// First record is a seed (S) record without identity and strand
+			Expect(records[0]).To(HaveLen(10))
+			Expect(records[0]).To(HaveKeyWithValue("record_type", "S"))
+			Expect(records[0]).To(HaveKeyWithValue("identity", BeNil()))
+			Expect(records[0]).To(HaveKeyWithValue("strand", BeNil()))
+
+			hits := 0
+			for _, record := range records {
+				if record["record_type"] == "H" {
+					Expect(record["identity"]).To(BeNumerically(">", 0))
+					Expect(record["strand"]).To(BeAssignableToTypeOf(""))
+					hits++
+				}
+			}
+			Expect(hits).To(Equal(24577))
+		})
+
+		It("should output only the selected fields", func() {
+			records := writeJSON(Options{
+				inputFile:  testFile,
+				mapOnly:    true,
+				splitSeqID: true,
+				removeDups: true,
+				jsonFields: "target,identity",
+			})
+			Expect(records).To(HaveLen(24953))
+			for _, record := range records {
+				Expect(record).To(HaveLen(2))
+				Expect(record).To(HaveKey("target"))
+				Expect(record).To(HaveKey("identity"))
+			}
+		})
+
+		It("should reject unknown fields", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: testFile, jsonFields: "query,otu"}
+			writer := bufio.NewWriter(&bytes.Buffer{})
+			Expect(processAndWriteJSON(input, writer, opts, nil)).To(MatchError(ContainSubstring(`unknown column "otu"`)))
+		})
+	})
+
+	// ---------- CSV output ----------
+
+	Context("CSV output", func() {
+		// UC records with labels containing a delimiter and quotes
+		const ucData = "S\t0\t100\t*\t*\t*\t*\t*\tseq\"1\",a\t*\n" +
+			"H\t0\t100\t99.5\t+\t0\t0\t100M\tseq|2\tseq\"1\",a\n"
+
+		writeCSV := func(opts Options) string {
+			inFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(inFile, []byte(ucData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(inFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts.inputFile = inFile
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteCSV(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+			return buf.String()
+		}
+
+		It("should quote fields according to RFC 4180", func() {
+			out := writeCSV(Options{mapOnly: true, delimiter: ","})
+			Expect(out).To(Equal("Query,Target\n" +
+				"\"seq\"\"1\"\",a\",\"seq\"\"1\"\",a\"\n" +
+				"seq|2,\"seq\"\"1\"\",a\"\n"))
+
+			rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows[2]).To(Equal([]string{"seq|2", "seq\"1\",a"}))
+		})
+
+		It("should use the same header as text output", func() {
+			header, _, err := textHeader(Options{})
+			Expect(err).NotTo(HaveOccurred())
+			out := writeCSV(Options{delimiter: `\t`})
+			Expect(out).To(HavePrefix(header))
+		})
+
+		It("should support other delimiters and header suppression", func() {
+			out := writeCSV(Options{delimiter: "|", noHeader: true})
+
+			r := csv.NewReader(strings.NewReader(out))
+			r.Comma = '|'
+			rows, err := r.ReadAll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[1]).To(Equal([]string{"H", "0", "100", "99.50", "+", "0", "0", "100M", "seq|2", "seq\"1\",a"}))
+
+			// Missing identity and strand are written as in text output
+			Expect(rows[0][3]).To(Equal("*"))
+			Expect(rows[0][4]).To(Equal("*"))
+		})
+
+		It("should format values as text output does", func() {
+			inFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(inFile, []byte(ucData), 0o644)).To(Succeed())
+			input, err := openInputFile(inFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: inFile, columns: "query,identity,strand,size"}
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			opts.delimiter = `\t`
+			Expect(writeCSV(opts)).To(Equal(strings.Replace(buf.String(), "seq\"1\",a", "\"seq\"\"1\"\",a\"", 1)))
+		})
+
+		It("should accept tab as a delimiter", func() {
+			Expect(parseDelimiter(`\t`)).To(Equal('\t'))
+			_, err := parseDelimiter("ab")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	// ---------- Column selection ----------
+
+	Context("Column selection", func() {
+		const ucData = "S\t0\t100\t*\t*\t*\t*\t*\tseq1;size=10;sample=A\t*\n" +
+			"H\t0\t100\t99.5\t+\t0\t0\t100M\tseq2;size=3;sample=B\tseq1;size=10;sample=A\n"
+
+		var inFile string
+		BeforeEach(func() {
+			inFile = filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(inFile, []byte(ucData), 0o644)).To(Succeed())
+		})
+
+		It("should write selected and derived columns to TSV", func() {
+			input, err := openInputFile(inFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  inFile,
+				splitSeqID: true,
+				columns:    "query,target,identity,cluster_number,query_size,sample",
+			}
+
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			Expect(buf.String()).To(Equal(
+				"query\ttarget\tidentity\tcluster_number\tquery_size\tsample\n" +
+					"seq1\tseq1\t*\t0\t10\tA\n" +
+					"seq2\tseq1\t99.50\t0\t3\tB\n"))
+		})
+
+		It("should generate the Parquet schema from the selected columns", func() {
+			input, err := openInputFile(inFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outFile := filepath.Join(tmpDir, "out.parquet")
+			opts := Options{
+				inputFile:  inFile,
+				splitSeqID: true,
+				columns:    "target,identity,query_size",
+			}
+			Expect(processAndWriteParquet(input, outFile, opts, nil)).To(Succeed())
+
+			type row struct {
+				Target    string   `parquet:"target"`
+				Identity  *float64 `parquet:"identity"`
+				QuerySize *uint32  `parquet:"query_size"`
+			}
+			rows, err := parquet.ReadFile[row](outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[0].Identity).To(BeNil())
+			Expect(*rows[1].Identity).To(Equal(99.5))
+			Expect(*rows[1].QuerySize).To(Equal(uint32(3)))
+
+			columns, err := parseColumns(opts.columns, Options{})
+			Expect(err).NotTo(HaveOccurred())
+			names := []string{}
+			for _, f := range parquetSchema(columns).Fields() {
+				names = append(names, f.Name())
+			}
+			Expect(names).To(Equal([]string{"target", "identity", "query_size"}))
+		})
+
+		It("should reject unknown and duplicate columns", func() {
+			_, err := parseColumns("query,otu", Options{})
+			Expect(err).To(MatchError(ContainSubstring(`unknown column "otu"`)))
+			_, err = parseColumns("query,query", Options{})
+			Expect(err).To(MatchError(ContainSubstring(`duplicate column "query"`)))
+		})
+
+		It("should reject columns for formats with a fixed layout", func() {
+			for _, format := range []string{"uc", "sqlite", "arrow", "arrow-stream"} {
+				Expect(checkColumns(format, Options{columns: "query"})).To(HaveOccurred())
+				Expect(checkColumns(format, Options{taxonomyFile: "tax.tsv"})).To(HaveOccurred())
+				Expect(checkColumns(format, Options{resolve: resolveFractional})).To(HaveOccurred())
+				Expect(checkColumns(format, Options{resolve: resolveBest})).To(Succeed())
+				Expect(checkColumns(format, Options{})).To(Succeed())
+			}
+			Expect(checkColumns("csv", Options{columns: "query"})).To(Succeed())
+		})
+	})
+
+	// ---------- Sequence extraction ----------
+
+	Context("Extract command", func() {
+		const ucData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1;size=10\t*\n" +
+			"S\t1\t4\t*\t*\t*\t*\t*\tseq3;size=5\t*\n" +
+			"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2;size=3\tseq1;size=10\n" +
+			"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq4;size=1\tseq3;size=5\n"
+		const fastqData = "@seq1;size=10\nACGT\n+\nIIII\n" +
+			"@seq2;size=3 sample A\nACGA\n+\nIIIH\n" +
+			"@seq3;size=5\nTTTT\n+\nIIII\n" +
+			"@seq4;size=1\nTTTA\n+\nIIIG\n" +
+			"@seq5\nGGGG\n+\nIIII\n"
+
+		var ucFile, seqFile string
+		BeforeEach(func() {
+			ucFile = filepath.Join(tmpDir, "in.uc")
+			seqFile = filepath.Join(tmpDir, "reads.fastq")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+			Expect(os.WriteFile(seqFile, []byte(fastqData), 0o644)).To(Succeed())
+		})
+
+		It("should relabel reads by their centroid", func() {
+			outFile := filepath.Join(tmpDir, "members.fastq")
+			Expect(runExtract([]string{"-i", ucFile, "-q", seqFile, "-o", outFile})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(
+				"@seq1 seq1;size=10\nACGT\n+\nIIII\n" +
+					"@seq1 seq2;size=3 sample A\nACGA\n+\nIIIH\n" +
+					"@seq3 seq3;size=5\nTTTT\n+\nIIII\n" +
+					"@seq3 seq4;size=1\nTTTA\n+\nIIIG\n"))
+		})
+
+		It("should write one file per cluster with limited open files", func() {
+			outDir := filepath.Join(tmpDir, "clusters")
+			Expect(runExtract([]string{"-i", ucFile, "-q", seqFile, "-O", outDir, "--max-open", "1"})).To(Succeed())
+
+			// Files are reopened in append mode after being closed
+			content, err := os.ReadFile(filepath.Join(outDir, "seq1.fastq"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("@seq1;size=10\nACGT\n+\nIIII\n@seq2;size=3 sample A\nACGA\n+\nIIIH\n"))
+
+			content, err = os.ReadFile(filepath.Join(outDir, "seq3.fastq"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("@seq3;size=5\nTTTT\n+\nIIII\n@seq4;size=1\nTTTA\n+\nIIIG\n"))
+		})
+
+		It("should parse multi-line FASTA", func() {
+			fastaFile := filepath.Join(tmpDir, "reads.fasta")
+			Expect(os.WriteFile(fastaFile, []byte(">seq1 first\nAC\nGT\n\n>seq2\nGG\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(fastaFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			seqs, err := openSeqReader(input, fastaFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(seqs.fastq).To(BeFalse())
+
+			record, err := seqs.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.ID()).To(Equal("seq1"))
+			Expect(record.seq).To(Equal("ACGT"))
+
+			record, err = seqs.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.ID()).To(Equal("seq2"))
+
+			_, err = seqs.Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should keep FASTQ framing with empty sequence lines", func() {
+			fastqFile := filepath.Join(tmpDir, "empty.fastq")
+			Expect(os.WriteFile(fastqFile, []byte("@seq1\n\n+\n\n\n@seq2\nACGT\n+\nIIII\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(fastqFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			seqs, err := openSeqReader(input, fastqFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			record, err := seqs.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.ID()).To(Equal("seq1"))
+			Expect(record.seq).To(BeEmpty())
+
+			record, err = seqs.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.ID()).To(Equal("seq2"))
+			Expect(record.qual).To(Equal("IIII"))
+		})
+
+		It("should write clusters with the same safe file name into separate files", func() {
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq/1\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq:1\t*\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(seqFile, []byte("@seq/1\nACGT\n+\nIIII\n@seq:1\nTTTT\n+\nIIII\n"), 0o644)).To(Succeed())
+
+			outDir := filepath.Join(tmpDir, "clusters")
+			Expect(runExtract([]string{"-i", ucFile, "-q", seqFile, "-O", outDir})).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(outDir, "seq_1.fastq"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("@seq/1\nACGT\n+\nIIII\n"))
+
+			content, err = os.ReadFile(filepath.Join(outDir, "seq_1_2.fastq"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("@seq:1\nTTTT\n+\nIIII\n"))
+		})
+	})
+
+	// ---------- Centroid relabelling ----------
+
+	Context("Centroid relabelling", func() {
+		const ucData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1;size=2\t*\n" +
+			"S\t1\t4\t*\t*\t*\t*\t*\tseq3;size=5\t*\n" +
+			"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2;size=1\tseq1;size=2\n" +
+			"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq4;size=1\tseq3;size=5\n"
+
+		var ucFile string
+		BeforeEach(func() {
+			ucFile = filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+		})
+
+		relabelOpts := func(mode string) Options {
+			return Options{
+				inputFile:     ucFile,
+				mapOnly:       true,
+				splitSeqID:    true,
+				removeDups:    true,
+				relabel:       mode,
+				relabelPrefix: "OTU_",
+			}
+		}
+
+		It("should number centroids by cluster number", func() {
+			labels, order, err := buildRelabelMap(relabelOpts("cluster"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(order).To(Equal([]string{"seq1", "seq3"}))
+			Expect(labels).To(Equal(map[string]string{"seq1": "OTU_1", "seq3": "OTU_2"}))
+		})
+
+		It("should number centroids by abundance rank and rewrite targets", func() {
+			opts := relabelOpts("abundance")
+			labels, order, err := buildRelabelMap(opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(order).To(Equal([]string{"seq3", "seq1"}))
+			Expect(labels).To(Equal(map[string]string{"seq3": "OTU_1", "seq1": "OTU_2"}))
+
+			tableFile := filepath.Join(tmpDir, "labels.tsv")
+			Expect(writeRelabelTable(tableFile, order, labels)).To(Succeed())
+			content, err := os.ReadFile(tableFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Centroid\tLabel\nseq3\tOTU_1\nseq1\tOTU_2\n"))
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts.relabelMap = labels
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+			Expect(buf.String()).To(Equal("Query\tTarget\nseq1\tOTU_2\nseq3\tOTU_1\nseq2\tOTU_2\nseq4\tOTU_1\n"))
+		})
+
+		It("should rename the S records of relabelled centroids in UC output", func() {
+			opts := relabelOpts("cluster")
+			labels, _, err := buildRelabelMap(opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts.relabelMap = labels
+			opts.mapOnly = false
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteUC(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+			Expect(buf.String()).To(Equal("S\t0\t4\t*\t*\t*\t*\t*\tOTU_1;size=2\t*\n" +
+				"S\t1\t4\t*\t*\t*\t*\t*\tOTU_2;size=5\t*\n" +
+				"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2;size=1\tOTU_1;size=2\n" +
+				"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq4;size=1\tOTU_2;size=5\n" +
+				"C\t0\t2\t*\t*\t*\t*\t*\tOTU_1;size=2\t*\n" +
+				"C\t1\t2\t*\t*\t*\t*\t*\tOTU_2;size=5\t*\n"))
+		})
+
+		It("should remove spooled stdin on exit cleanups", func() {
+			spool := filepath.Join(tmpDir, "ucs-stdin-test")
+			Expect(os.WriteFile(spool, []byte(ucData), 0o644)).To(Succeed())
+
+			var order []int
+			exitCleanups = append(exitCleanups, func() { os.Remove(spool); order = append(order, 1) })
+			exitCleanups = append(exitCleanups, func() { order = append(order, 2) })
+			runExitCleanups()
+
+			Expect(order).To(Equal([]int{2, 1}))
+			Expect(exitCleanups).To(BeEmpty())
+			Expect(spool).NotTo(BeAnExistingFile())
+		})
+
+		It("should label centroids by the SHA1 of their sequence", func() {
+			seqFile := filepath.Join(tmpDir, "centroids.fasta")
+			Expect(os.WriteFile(seqFile, []byte(">seq1;size=2\nacgt\n>seq3;size=5\nTTTT\n"), 0o644)).To(Succeed())
+
+			opts := relabelOpts("hash")
+			opts.seqFile = seqFile
+			labels, _, err := buildRelabelMap(opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// SHA1 of the upper-cased sequences
+			Expect(labels["seq1"]).To(Equal("2108994e17f6cca9ff2352ada92b6511db076034"))
+			Expect(labels["seq3"]).To(HaveLen(40))
+
+			// Missing centroid sequences are reported
+			Expect(os.WriteFile(seqFile, []byte(">seq1\nACGT\n"), 0o644)).To(Succeed())
+			_, _, err = buildRelabelMap(opts)
+			Expect(err).To(MatchError(ContainSubstring("seq3 not found")))
+		})
+	})
+
+	// ---------- Taxonomy ----------
+
+	Context("Taxonomy join", func() {
+		It("should parse SINTAX, QIIME and positional taxonomy strings", func() {
+			Expect(parseTaxonomy("d:Bacteria(1.00),p:Firmicutes(0.87)")).To(Equal([]taxon{
+				{"domain", "Bacteria", 1.0},
+				{"phylum", "Firmicutes", 0.87},
+			}))
+			Expect(parseTaxonomy("k__Fungi; p__Ascomycota; c__")).To(Equal([]taxon{
+				{"kingdom", "Fungi", -1},
+				{"phylum", "Ascomycota", -1},
+			}))
+			Expect(parseTaxonomy("Fungi;Ascomycota")).To(Equal([]taxon{
+				{"kingdom", "Fungi", -1},
+				{"phylum", "Ascomycota", -1},
+			}))
+		})
+
+		It("should append filtered taxonomy columns to the mapping", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			taxFile := filepath.Join(tmpDir, "sintax.txt")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1;size=10\t*\n"+
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2;size=3\tseq1;size=10\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq3;size=1\t*\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(taxFile, []byte(
+				"seq1;size=10\td:Bacteria(1.00),p:Firmicutes(0.90),g:Bacillus(0.50)\t+\td:Bacteria,p:Firmicutes\n"), 0o644)).To(Succeed())
+
+			taxonomy, err := loadTaxonomy(taxFile, 0.8, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taxonomy.ranks).To(Equal([]string{"domain", "phylum", "genus"}))
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  ucFile,
+				mapOnly:    true,
+				splitSeqID: true,
+				removeDups: true,
+				taxonomy:   taxonomy,
+			}
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+
+			Expect(buf.String()).To(Equal(
+				"Query\tTarget\ttaxonomy\tdomain\tphylum\tgenus\n" +
+					"seq1\tseq1\td:Bacteria,p:Firmicutes\tBacteria\tFirmicutes\t*\n" +
+					"seq2\tseq1\td:Bacteria,p:Firmicutes\tBacteria\tFirmicutes\t*\n" +
+					"seq3\tseq3\t*\t*\t*\t*\n"))
+		})
+	})
+
+	// ---------- Sample map ----------
+
+	Context("Sample map", func() {
+		It("should assign reads by exact ID, prefix and regular expression", func() {
+			mapFile := filepath.Join(tmpDir, "samples.tsv")
+			Expect(os.WriteFile(mapFile, []byte(
+				"# read\tsample\n"+
+					"seq1\tA\n"+
+					"seq*\tB\n"+
+					"seq2*\tC\n"+
+					"/^read_(S\\d+)_/\tsample_$1\n"), 0o644)).To(Succeed())
+
+			m, err := loadSampleMap(mapFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			for id, expected := range map[string]string{
+				"seq1":          "A",
+				"seq10":         "B",
+				"seq25":         "C",
+				"read_S12_0001": "sample_S12",
+			} {
+				sample, ok := m.lookup(id)
+				Expect(ok).To(BeTrue())
+				Expect(sample).To(Equal(expected))
+			}
+
+			_, ok := m.lookup("other")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should set the sample of queries in processRecords", func() {
+			mapFile := filepath.Join(tmpDir, "samples.tsv")
+			Expect(os.WriteFile(mapFile, []byte("seq2*\tB\n"), 0o644)).To(Succeed())
+			m, err := loadSampleMap(mapFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1;sample=A\t*\n"+
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2\tseq1;sample=A\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: ucFile, mapOnly: true, splitSeqID: true, sampleMap: m}
+			reader, err := openRecordReader(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Unmatched reads fall back to the sample annotation
+			samples := []string{}
+			Expect(processRecords(reader, opts, func(record UCRecord) error {
+				sample, _ := recordSample(record)
+				samples = append(samples, sample)
+				return nil
+			}, nil)).To(Succeed())
+			Expect(samples).To(Equal([]string{"A", "B"}))
+		})
+	})
+
+	// ---------- Split output ----------
+
+	Context("Split output", func() {
+		It("should write one TSV file per target with limited open files", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outDir := filepath.Join(tmpDir, "split")
+			opts := Options{
+				inputFile:  testFile,
+				outputFile: outDir,
+				mapOnly:    true,
+				splitSeqID: true,
+				removeDups: true,
+				splitBy:    "target",
+				maxOpen:    8,
+			}
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+
+			// Index lists every file with its number of records
+			content, err := os.ReadFile(filepath.Join(outDir, splitIndexFile))
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			Expect(lines[0]).To(Equal("Key\tFile\tRecords"))
+			Expect(lines).To(HaveLen(376 + 1))
+
+			total := 0
+			for _, line := range lines[1:] {
+				cols := strings.Split(line, "\t")
+				data, err := os.ReadFile(filepath.Join(outDir, cols[1]))
+				Expect(err).NotTo(HaveOccurred())
+
+				rows := strings.Split(strings.TrimSpace(string(data)), "\n")
+				Expect(rows[0]).To(Equal("Query\tTarget"))
+				Expect(strconv.Itoa(len(rows) - 1)).To(Equal(cols[2]))
+				for _, row := range rows[1:] {
+					Expect(strings.Split(row, "\t")[1]).To(Equal(cols[0]))
+				}
+				total += len(rows) - 1
+			}
+			Expect(total).To(Equal(24953))
+		})
+
+		It("should write one Parquet file per sample", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1;sample=A\t*\n"+
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2;sample=B\tseq1;sample=A\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq3;sample=A\tseq1;sample=A\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq4\t*\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outDir := filepath.Join(tmpDir, "samples")
+			opts := Options{
+				inputFile:  ucFile,
+				outputFile: outDir,
+				format:     "parquet",
+				mapOnly:    true,
+				splitSeqID: true,
+				removeDups: true,
+				splitBy:    "sample",
+			}
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+
+			records, err := parquet.ReadFile[MapRecord](filepath.Join(outDir, "A.parquet"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(Equal([]MapRecord{{"seq1", "seq1"}, {"seq3", "seq1"}}))
+
+			content, err := os.ReadFile(filepath.Join(outDir, splitIndexFile))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Key\tFile\tRecords\n" +
+				"A\tA.parquet\t2\n" +
+				"B\tB.parquet\t1\n" +
+				"unassigned\tunassigned.parquet\t1\n"))
+		})
+
+		It("should split by cluster with the map-only header", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outDir := filepath.Join(tmpDir, "clusters")
+			opts := Options{inputFile: testFile, outputFile: outDir, mapOnly: true, splitSeqID: true, removeDups: true, splitBy: "cluster"}
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(outDir, "0.tsv"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(HavePrefix("Query\tTarget\n"))
+		})
+
+		It("should write Parquet rows in batches while files are closed and reopened", func() {
+			schema := parquet.SchemaOf(MapRecord{})
+			pool := newParquetPool(1, 2, schema, func(row parquet.Row, record UCRecord) parquet.Row {
+				return schema.Deconstruct(row[:0], MapRecord{Query: record.Query, Target: record.Target})
+			})
+
+			a, b := filepath.Join(tmpDir, "a.parquet"), filepath.Join(tmpDir, "b.parquet")
+			for i := range 7 {
+				path := a
+				if i%2 == 1 {
+					path = b
+				}
+				Expect(pool.Write(path, UCRecord{Query: fmt.Sprintf("q%d", i), Target: filepath.Base(path)})).To(Succeed())
+			}
+			Expect(pool.Close()).To(Succeed())
+
+			records, err := parquet.ReadFile[MapRecord](a)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(Equal([]MapRecord{{"q0", "a.parquet"}, {"q2", "a.parquet"}, {"q4", "a.parquet"}, {"q6", "a.parquet"}}))
+			records, err = parquet.ReadFile[MapRecord](b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(3))
+		})
+
+		It("should reopen Parquet files closed by the pool and keep the index file", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tindex\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq2\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq3\tindex\n"+
+					"H\t1\t4\t99.0\t+\t0\t0\t4M\tseq4\tseq2\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq5\tindex\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outDir := filepath.Join(tmpDir, "targets")
+			opts := Options{
+				inputFile:  ucFile,
+				outputFile: outDir,
+				format:     "parquet",
+				splitSeqID: true,
+				removeDups: true,
+				splitBy:    "target",
+				maxOpen:    1,
+			}
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+
+			records, err := parquet.ReadFile[ParquetRecord](filepath.Join(outDir, "index.parquet"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(3))
+			Expect(records[2].Query).To(Equal("seq5"))
+
+			opts.format = ""
+			opts.outputFile = filepath.Join(tmpDir, "tsv")
+			input.Seek(0, io.SeekStart)
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+			content, err := os.ReadFile(filepath.Join(opts.outputFile, splitIndexFile))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Key\tFile\tRecords\n" +
+				"index\tindex_2.tsv\t3\n" +
+				"seq2\tseq2.tsv\t2\n"))
+		})
+
+		It("should keep fractional weights in Parquet files", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq3\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq2\tseq1\n"+
+					"H\t1\t4\t99.0\t+\t0\t0\t4M\tseq2\tseq3\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			outDir := filepath.Join(tmpDir, "targets")
+			opts := Options{
+				inputFile:  ucFile,
+				outputFile: outDir,
+				format:     "parquet",
+				columns:    "query,target,weight",
+				splitSeqID: true,
+				removeDups: true,
+				resolve:    resolveFractional,
+				splitBy:    "target",
+			}
+			Expect(processAndWriteSplit(input, opts, nil)).To(Succeed())
+
+			type row struct {
+				Query  string   `parquet:"query"`
+				Weight *float64 `parquet:"weight"`
+			}
+			rows, err := parquet.ReadFile[row](filepath.Join(outDir, "seq1.parquet"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(*rows[0].Weight).To(Equal(1.0))
+			Expect(rows[1].Query).To(Equal("seq2"))
+			Expect(*rows[1].Weight).To(Equal(0.5))
+		})
+	})
+
+	// ---------- Graph export ----------
+
+	Context("Graph export", func() {
+		const ucData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n" +
+			"S\t1\t4\t*\t*\t*\t*\t*\tseq3\t*\n" +
+			"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2\tseq1\n" +
+			"H\t0\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq1\n" +
+			"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq4\tseq3\n" +
+			"S\t2\t4\t*\t*\t*\t*\t*\tseq5\t*\n"
+
+		var ucFile string
+		BeforeEach(func() {
+			ucFile = filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+		})
+
+		It("should write a weighted edge list without singletons", func() {
+			outFile := filepath.Join(tmpDir, "edges.tsv")
+			Expect(runGraph([]string{"-i", ucFile, "-o", outFile, "--collapse-singletons"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Source\tTarget\tWeight\n" +
+				"seq2\tseq1\t99.5\n" +
+				"seq4\tseq1\t97\n" +
+				"seq4\tseq3\t98\n"))
+		})
+
+		It("should write a bipartite GraphML graph", func() {
+			outFile := filepath.Join(tmpDir, "graph.graphml")
+			Expect(runGraph([]string{"-i", ucFile, "-o", outFile, "--bipartite"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			var doc struct {
+				Nodes []struct {
+					ID string `xml:"id,attr"`
+				} `xml:"graph>node"`
+				Edges []struct {
+					Source string `xml:"source,attr"`
+					Target string `xml:"target,attr"`
+				} `xml:"graph>edge"`
+			}
+			Expect(xml.Unmarshal(content, &doc)).To(Succeed())
+
+			// Five sequences and three clusters; one edge per hit and per centroid
+			Expect(doc.Nodes).To(HaveLen(8))
+			Expect(doc.Edges).To(HaveLen(6))
+			Expect(doc.Edges[0].Source).To(Equal("seq:seq1"))
+			Expect(doc.Edges[0].Target).To(Equal("cluster:seq1"))
+		})
+
+		It("should infer the graph format from the file extension", func() {
+			Expect(graphFormat("clusters.gexf")).To(Equal("gexf"))
+			Expect(graphFormat("clusters.gv")).To(Equal("dot"))
+			Expect(graphFormat("-")).To(Equal("edgelist"))
+		})
+	})
+
+	// ---------- Connected components ----------
+
+	Context("Connected components", func() {
+		It("should link targets sharing queries", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq3\t*\n"+
+					"S\t2\t4\t*\t*\t*\t*\t*\tseq5\t*\n"+
+					"S\t3\t4\t*\t*\t*\t*\t*\tseq7\t*\n"+
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2\tseq1\n"+
+					"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq2\tseq3\n"+
+					"H\t1\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq3\n"+
+					"H\t2\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq5\n"+
+					"H\t3\t4\t97.0\t+\t0\t0\t4M\tseq8\tseq7\n"+
+					"N\t*\t4\t*\t*\t*\t*\t*\tseq9\t*\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "components.tsv")
+			Expect(runComponents([]string{"-i", ucFile, "-o", outFile, "--all"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Component\tTargets\tQueries\tBridging\tTargetIDs\tBridgingQueries\n" +
+				"1\t3\t5\t2\tseq1,seq3,seq5\tseq2,seq4\n" +
+				"2\t1\t2\t0\tseq7\t*\n"))
+		})
+
+		It("should find no linked targets in the test file", func() {
+			input, err := openInputFile(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: testFile, mapOnly: true, splitSeqID: true, removeDups: true}
+			reader, err := openRecordReader(input, testFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			components, err := findComponents(reader, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(components).To(HaveLen(376))
+			Expect(components[0].targets).To(HaveLen(1))
+		})
+	})
+
+	// ---------- Multi-hit resolution ----------
+
+	Context("Multi-hit resolution", func() {
+		const ucData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1;size=10\t*\n" +
+			"S\t1\t4\t*\t*\t*\t*\t*\tseq3;size=5\t*\n" +
+			"H\t0\t4\t98.0\t+\t0\t0\t4M\tseq2;size=3\tseq3;size=5\n" +
+			"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq2;size=3\tseq1;size=10\n" +
+			"H\t1\t4\t97.0\t+\t0\t0\t4M\tseq4;size=2\tseq1;size=10\n" +
+			"H\t1\t4\t99.0\t+\t0\t0\t4M\tseq4;size=2\tseq3;size=5\n"
+
+		resolve := func(policy, tieBreak string) []string {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				inputFile:  ucFile,
+				splitSeqID: true,
+				removeDups: true,
+				resolve:    policy,
+				tieBreak:   tieBreak,
+				seed:       1,
+			}
+			Expect(checkResolve(opts)).To(Succeed())
+			reader, err := openRecordReader(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var pairs []string
+			Expect(processRecords(reader, opts, func(r UCRecord) error {
+				pair := r.Query + ">" + r.Target
+				if r.Weight != nil {
+					pair += fmt.Sprintf(":%g", *r.Weight)
+				}
+				pairs = append(pairs, pair)
+				return nil
+			}, nil)).To(Succeed())
+			return pairs
+		}
+
+		It("should keep the best hit with input order or abundance tie-break", func() {
+			Expect(resolve(resolveBest, "order")).To(Equal([]string{"seq1>seq1", "seq3>seq3", "seq2>seq3", "seq4>seq3"}))
+			Expect(resolve(resolveBest, "abundance")).To(Equal([]string{"seq1>seq1", "seq3>seq3", "seq2>seq1", "seq4>seq3"}))
+		})
+
+		It("should pick a reproducible random hit", func() {
+			first := resolve(resolveRandom, "order")
+			Expect(first).To(HaveLen(4))
+			Expect(resolve(resolveRandom, "order")).To(Equal(first))
+		})
+
+		It("should split abundance across hits", func() {
+			Expect(resolve(resolveFractional, "order")).To(Equal([]string{
+				"seq1>seq1:10", "seq3>seq3:5", "seq2>seq3:1.5", "seq2>seq1:1.5", "seq4>seq1:1", "seq4>seq3:1"}))
+		})
+
+		It("should assign multi-mapped queries to the ambiguous bucket", func() {
+			Expect(resolve(resolveAmbiguous, "order")).To(Equal([]string{"seq1>seq1", "seq3>seq3", "seq2>ambiguous", "seq4>ambiguous"}))
+		})
+
+		It("should keep the default text layout and append the weight", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			write := func(policy string) string {
+				input, err := openInputFile(ucFile)
+				Expect(err).NotTo(HaveOccurred())
+				defer input.Close()
+
+				opts := Options{inputFile: ucFile, mapOnly: true, splitSeqID: true, removeDups: true, resolve: policy, tieBreak: "order"}
+				var buf bytes.Buffer
+				writer := bufio.NewWriter(&buf)
+				Expect(processAndWriteText(input, writer, opts, nil)).To(Succeed())
+				Expect(writer.Flush()).To(Succeed())
+				return buf.String()
+			}
+
+			Expect(write(resolveBest)).To(Equal("Query\tTarget\n" +
+				"seq1\tseq1\nseq3\tseq3\nseq2\tseq3\nseq4\tseq3\n"))
+			Expect(write(resolveFractional)).To(HavePrefix("Query\tTarget\tweight\n" +
+				"seq1\tseq1\t10\nseq3\tseq3\t5\nseq2\tseq3\t1.5\n"))
+		})
+
+		It("should write weights without rounding", func() {
+			columns, err := parseColumns("query,identity,weight", Options{resolve: resolveFractional})
+			Expect(err).NotTo(HaveOccurred())
+
+			identity, weight := 99.5, 1.0/3
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			Expect(writeUCRecord(writer, UCRecord{Query: "seq1", Identity: &identity, Weight: &weight}, Options{}, columns)).To(Succeed())
+			Expect(writer.Flush()).To(Succeed())
+			Expect(buf.String()).To(Equal("seq1\t99.50\t0.3333333333333333\n"))
+		})
+
+		It("should reject unknown policies", func() {
+			Expect(checkResolve(Options{resolve: "lca", tieBreak: "order"})).NotTo(Succeed())
+			Expect(checkResolve(Options{resolve: resolveBest, tieBreak: "order", multiMapped: true})).NotTo(Succeed())
+		})
+	})
+
+	// ---------- Alpha diversity ----------
+
+	Context("Alpha diversity", func() {
+		It("should compute diversity estimates per sample", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\ta1;sample=A\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\ta2;sample=A\ta1;sample=A\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\ta3;sample=A;size=3\ta1;sample=A\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\ta4;sample=A;size=2\t*\n"+
+					"S\t2\t4\t*\t*\t*\t*\t*\tb1;sample=B\t*\n"+
+					"H\t2\t4\t99.0\t+\t0\t0\t4M\tb2;sample=B\tb1;sample=B\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tb3;sample=B\ta1;sample=A\n"+
+					"N\t*\t4\t*\t*\t*\t*\t*\tx1\t*\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "alpha.tsv")
+			Expect(runAlpha([]string{"-i", ucFile, "-o", outFile})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Sample\tReads\tRichness\tShannon\tSimpson\tChao1\tACE\tGoodsCoverage\n" +
+				"A\t7\t2\t0.5983\t0.4082\t2.0000\t2.0000\t1.0000\n" +
+				"B\t3\t2\t0.6365\t0.4444\t2.0000\t3.0000\t0.6667\n"))
+		})
+
+		It("should count the reads of multi-mapped queries once", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tc1;sample=A\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tc2;sample=A\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tb1;sample=B;size=1\tc1;sample=A\n"+
+					"H\t1\t4\t98.0\t+\t0\t0\t4M\tb1;sample=B;size=1\tc2;sample=A\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "alpha.tsv")
+			Expect(runAlpha([]string{"-i", ucFile, "-o", outFile})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Split(string(content), "\n")[2]).To(HavePrefix("B\t1\t1\t"))
+		})
+
+		It("should estimate unseen OTUs from singletons", func() {
+			// Four singletons and one doubleton
+			a := computeAlpha([]float64{1, 1, 1, 1, 2})
+			Expect(a.richness).To(Equal(5))
+			Expect(a.chao1).To(BeNumerically("~", 5+4.0*3/4, 1e-9))
+			Expect(a.coverage).To(BeNumerically("~", 1-4.0/6, 1e-9))
+
+			// ACE is undefined when all OTUs are singletons
+			Expect(math.IsNaN(computeAlpha([]float64{1, 1, 1}).ace)).To(BeTrue())
+		})
+	})
+
+	// ---------- Rarefaction ----------
+
+	Context("Rarefaction", func() {
+		It("should rarefy each sample reproducibly", func() {
+			opts := diversityOptions{inputFile: testFile, splitSeqID: true, tieBreak: "order", seed: 7, depth: 1000}
+			first, err := loadSampleCounts(opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(computeAlpha(first.abundances(unassignedSample)).reads).To(Equal(1000.0))
+
+			second, err := loadSampleCounts(opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second.counts).To(Equal(first.counts))
+		})
+
+		It("should drop samples with fewer reads than the depth", func() {
+			c := &sampleCounts{
+				samples: []string{"A", "B"},
+				groups:  []readGroup{{"A", "otu1", 5}, {"B", "otu1", 1}, {"A", "otu2", 3}},
+			}
+			Expect(c.rarefy(4, false, newRarefyRand(1))).To(Succeed())
+			Expect(c.samples).To(Equal([]string{"A"}))
+			Expect(totalReads(c.groups)).To(Equal(4))
+
+			// Pooled depth cannot exceed all reads
+			Expect(c.rarefy(10, true, newRarefyRand(1))).NotTo(Succeed())
+		})
+
+		It("should draw hypergeometric counts without per-read work", func() {
+			rng := newRarefyRand(1)
+			sum := 0
+			for range 2000 {
+				k := hypergeometric(rng, 100, 30, 50)
+				Expect(k).To(BeNumerically(">=", 0))
+				Expect(k).To(BeNumerically("<=", 30))
+				sum += k
+			}
+			Expect(float64(sum) / 2000).To(BeNumerically("~", 15, 0.3))
+			Expect(hypergeometric(rng, 10, 10, 4)).To(Equal(4))
+
+			// Billions of reads are rarefied in time proportional to the number of OTUs
+			groups := []readGroup{{"A", "otu1", 3e9}, {"A", "otu2", 1e9}, {"A", "otu3", 1}}
+			sampled := subsample(groups, 1_000_000, rng)
+			Expect(totalReads(sampled)).To(Equal(1_000_000))
+			Expect(sampled[0].reads).To(BeNumerically("~", 750_000, 5000))
+		})
+
+		It("should write rarefaction curves ending at the sample depth", func() {
+			outFile := filepath.Join(tmpDir, "curves.tsv")
+			Expect(runRarefy([]string{"-i", testFile, "-o", outFile, "--step", "10000"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			Expect(lines[0]).To(Equal("Sample\tDepth\tOTUs"))
+			Expect(lines).To(HaveLen(4))
+			Expect(lines[3]).To(Equal("unassigned\t24953\t376"))
+		})
+	})
+
+	// ---------- Beta diversity ----------
+
+	Context("Beta diversity", func() {
+		counts := func() *sampleCounts {
+			c := &sampleCounts{
+				samples: []string{"A", "B"},
+				groups:  []readGroup{{"A", "otu1", 5}, {"A", "otu2", 2}, {"B", "otu1", 1}, {"B", "otu3", 2}},
+			}
+			c.count()
+			return c
+		}
+
+		It("should compute Bray-Curtis, Jaccard and Aitchison distances", func() {
+			d, err := distanceMatrix(counts(), "braycurtis", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d[0][1]).To(BeNumerically("~", 0.8, 1e-9))
+			Expect(d[1][0]).To(Equal(d[0][1]))
+			Expect(d[0][0]).To(BeZero())
+
+			d, err = distanceMatrix(counts(), "jaccard", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d[0][1]).To(BeNumerically("~", 2.0/3, 1e-9))
+
+			d, err = distanceMatrix(counts(), "aitchison", 1)
+			Expect(err).NotTo(HaveOccurred())
+			a := clr([]float64{5, 2, 0}, 1)
+			b := clr([]float64{1, 0, 2}, 1)
+			Expect(d[0][1]).To(BeNumerically("~", euclidean(a, b), 1e-9))
+
+			_, err = distanceMatrix(counts(), "unifrac", 1)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should write a PHYLIP distance matrix", func() {
+			var buf bytes.Buffer
+			Expect(writeDistancesPhylip(&buf, []string{"A", "B"}, [][]float64{{0, 0.8}, {0.8, 0}})).To(Succeed())
+			Expect(buf.String()).To(Equal("2\nA          0.000000 0.800000\nB          0.800000 0.000000\n"))
+		})
+	})
+
+	// ---------- Identity statistics ----------
+
+	Context("Identity statistics", func() {
+		It("should summarise identities per cluster and flag low-identity clusters", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseq3\t*\n"+
+					"S\t2\t4\t*\t*\t*\t*\t*\tseq6\t*\n"+
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tseq2\tseq1\n"+
+					"H\t1\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq3\n"+
+					"H\t1\t4\t98.0\t+\t0\t0\t4M\tseq5\tseq3\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "identity.tsv")
+			histFile := filepath.Join(tmpDir, "histogram.tsv")
+			Expect(runIdentity([]string{"-i", ucFile, "-o", outFile, "-H", histFile, "--low-identity", "98"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Cluster\tCentroid\tMembers\tMeanIdentity\tMinIdentity\tMaxIdentity\tLowIdentity\n" +
+				"0\tseq1\t1\t99.50\t99.50\t99.50\tfalse\n" +
+				"1\tseq3\t2\t97.50\t97.00\t98.00\ttrue\n" +
+				"2\tseq6\t0\t*\t*\t*\tfalse\n"))
+
+			content, err = os.ReadFile(histFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Lower\tUpper\tCount\n97\t98\t1\n98\t99\t1\n99\t100\t1\n"))
+		})
+
+		It("should write the report as JSON", func() {
+			outFile := filepath.Join(tmpDir, "identity.json")
+			Expect(runIdentity([]string{"-i", testFile, "-o", outFile, "--bin-width", "0.5"})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			var report struct {
+				Clusters  []map[string]any `json:"clusters"`
+				Histogram []struct {
+					Count int `json:"count"`
+				} `json:"histogram"`
+			}
+			Expect(json.Unmarshal(content, &report)).To(Succeed())
+			Expect(report.Clusters).To(HaveLen(376))
+
+			total := 0
+			for _, b := range report.Histogram {
+				total += b.Count
+			}
+			Expect(total).To(Equal(24577))
+		})
+	})
+
+	// ---------- Diff ----------
+
+	Context("Diff command", func() {
+		const oldData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n" +
+			"S\t1\t4\t*\t*\t*\t*\t*\tseq3\t*\n" +
+			"H\t0\t4\t98.0\t+\t0\t0\t4M\tseq2\tseq1\n" +
+			"H\t1\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq3\n" +
+			"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq5\tseq1\n"
+		const newData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n" +
+			"N\t*\t4\t*\t*\t*\t*\t*\tseq3\t*\n" +
+			"H\t0\t4\t98.6\t+\t0\t0\t4M\tseq2\tseq1\n" +
+			"H\t0\t4\t97.0\t+\t0\t0\t4M\tseq4\tseq1\n" +
+			"H\t0\t4\t99.2\t+\t0\t0\t4M\tseq6\tseq1\n"
+
+		var oldFile, newFile string
+		BeforeEach(func() {
+			oldFile = filepath.Join(tmpDir, "old.uc")
+			newFile = filepath.Join(tmpDir, "new.uc")
+			Expect(os.WriteFile(oldFile, []byte(oldData), 0o644)).To(Succeed())
+			Expect(os.WriteFile(newFile, []byte(newData), 0o644)).To(Succeed())
+		})
+
+		It("should report added, removed and changed queries", func() {
+			outFile := filepath.Join(tmpDir, "diff.tsv")
+			err := runDiff([]string{"-a", oldFile, "-b", newFile, "-o", outFile, "--identity-delta", "0.5"})
+
+			// Differences are signalled by exit status 1
+			var status *exitStatus
+			Expect(errors.As(err, &status)).To(BeTrue())
+			Expect(status.code).To(Equal(1))
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Change\tQuery\tOld\tNew\n" +
+				"record_type\tseq3\tS\tN\n" +
+				"target\tseq3\tseq3\t*\n" +
+				"identity\tseq2\tseq1:98.0\tseq1:98.6\n" +
+				"target\tseq4\tseq3\tseq1\n" +
+				"removed\tseq5\tseq1\t*\n" +
+				"added\tseq6\t*\tseq1\n"))
+		})
+
+		It("should succeed for identical files", func() {
+			Expect(runDiff([]string{"-a", testFile, "-b", testFile, "-o", filepath.Join(tmpDir, "diff.tsv")})).To(Succeed())
+		})
+
+		It("should exit with status 2 on errors", func() {
+			err := runDiff([]string{"-a", oldFile, "-b", filepath.Join(tmpDir, "missing.uc")})
+			var status *exitStatus
+			Expect(errors.As(err, &status)).To(BeTrue())
+			Expect(status.code).To(Equal(diffErrorStatus))
+		})
+	})
+
+	// ---------- Merge ----------
+
+	Context("Merge command", func() {
+		It("should renumber clusters and regenerate C records", func() {
+			chunk1 := filepath.Join(tmpDir, "chunk1.uc")
+			chunk2 := filepath.Join(tmpDir, "chunk2.uc")
+			Expect(os.WriteFile(chunk1, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseqA\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseqB\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tq1\tseqA\n"+
+					"H\t1\t4\t98.0\t+\t0\t0\t4M\tq2\tseqB\n"+
+					"C\t0\t2\t*\t*\t*\t*\t*\tseqA\t*\n"+
+					"C\t1\t2\t*\t*\t*\t*\t*\tseqB\t*\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(chunk2, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseqC\t*\n"+
+					"S\t1\t4\t*\t*\t*\t*\t*\tseqA\t*\n"+
+					"H\t1\t4\t99.5\t+\t0\t0\t4M\tq3\tseqA\n"+
+					"H\t0\t4\t97.0\t+\t0\t0\t4M\tq4\tseqC\n"+
+					"C\t0\t2\t*\t*\t*\t*\t*\tseqC\t*\n"+
+					"C\t1\t2\t*\t*\t*\t*\t*\tseqA\t*\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "merged.uc")
+			Expect(runMerge([]string{"-o", outFile, chunk1, chunk2})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(
+				"S\t0\t4\t*\t*\t*\t*\t*\tseqA\t*\n" +
+					"S\t1\t4\t*\t*\t*\t*\t*\tseqB\t*\n" +
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tq1\tseqA\n" +
+					"H\t1\t4\t98.0\t+\t0\t0\t4M\tq2\tseqB\n" +
+					"S\t2\t4\t*\t*\t*\t*\t*\tseqC\t*\n" +
+					"H\t0\t4\t99.5\t+\t0\t0\t4M\tq3\tseqA\n" +
+					"H\t2\t4\t97.0\t+\t0\t0\t4M\tq4\tseqC\n" +
+					"C\t0\t3\t*\t*\t*\t*\t*\tseqA\t*\n" +
+					"C\t1\t2\t*\t*\t*\t*\t*\tseqB\t*\n" +
+					"C\t2\t2\t*\t*\t*\t*\t*\tseqC\t*\n"))
+		})
+
+		It("should merge a centroid that is a member in another input", func() {
+			chunk1 := filepath.Join(tmpDir, "chunk1.uc")
+			chunk2 := filepath.Join(tmpDir, "chunk2.uc")
+			Expect(os.WriteFile(chunk1, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tA\t*\n"+
+					"H\t0\t4\t99.0\t+\t0\t0\t4M\tq1\tA\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(chunk2, []byte(
+				"S\t0\t4\t*\t*\t*\t*\t*\tB\t*\n"+
+					"H\t0\t4\t98.0\t+\t0\t0\t4M\tA\tB\n"), 0o644)).To(Succeed())
+
+			// Output file extension is checked after the compression suffix
+			outFile := filepath.Join(tmpDir, "merged.uc.gz")
+			Expect(runMerge([]string{"-o", outFile, chunk1, chunk2})).To(Succeed())
+
+			input, err := openInputFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+			scanner, err := createScanner(input, outFile)
+			Expect(err).NotTo(HaveOccurred())
+			var lines []string
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			Expect(lines).To(Equal([]string{
+				"H\t0\t4\t99.0\t+\t0\t0\t4M\tq1\tB",
+				"S\t0\t4\t*\t*\t*\t*\t*\tB\t*",
+				"H\t0\t4\t98.0\t+\t0\t0\t4M\tA\tB",
+				"C\t0\t3\t*\t*\t*\t*\t*\tB\t*"}))
+		})
+
+		It("should reproduce a single input", func() {
+			outFile := filepath.Join(tmpDir, "merged.tsv")
+			Expect(runMerge([]string{"-o", outFile, "-c", "query,target", testFile, testFile})).To(Succeed())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			Expect(lines[0]).To(Equal("query\ttarget"))
+			Expect(lines).To(HaveLen(376 + 24577 + 1))
+		})
+	})
+
+	// ---------- Search mode ----------
+
+	Context("Search mode", func() {
+		const searchData = "H\t12\t4\t99.0\t+\t0\t0\t4M\tq1\tt1\n" +
+			"N\t*\t4\t*\t*\t*\t*\t*\tq2\t*\n" +
+			"H\t3\t4\t98.0\t+\t0\t0\t4M\tq3\tt2\n"
+
+		pairs := func(mode string) []string {
+			ucFile := filepath.Join(tmpDir, "search.uc")
+			Expect(os.WriteFile(ucFile, []byte(searchData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: ucFile, mode: mode, mapOnly: true, splitSeqID: true, removeDups: true}
+			reader, err := openRecordReader(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var result []string
+			Expect(processRecords(reader, opts, func(r UCRecord) error {
+				result = append(result, r.Query+">"+r.Target)
+				return nil
+			}, nil)).To(Succeed())
+			return result
+		}
+
+		It("should detect searches and skip unmatched queries", func() {
+			Expect(pairs(modeAuto)).To(Equal([]string{"q1>t1", "q3>t2"}))
+			Expect(pairs(modeSearch)).To(Equal([]string{"q1>t1", "q3>t2"}))
+		})
+
+		It("should keep self-mappings of N records in cluster mode", func() {
+			Expect(pairs(modeCluster)).To(Equal([]string{"q1>t1", "q2>q2", "q3>t2"}))
+		})
+
+		It("should detect clusterings from S records after unmatched queries", func() {
+			ucFile := filepath.Join(tmpDir, "late.uc")
+			Expect(os.WriteFile(ucFile, []byte("N\t*\t4\t*\t*\t*\t*\t*\tq0\t*\n"+
+				"H\t0\t4\t99.0\t+\t0\t0\t4M\tq1\tc1\n"+
+				"S\t0\t4\t*\t*\t*\t*\t*\tc1\t*\n"), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{inputFile: ucFile, mapOnly: true, splitSeqID: true, removeDups: true}
+			reader, err := openRecordReader(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var result []string
+			Expect(processRecords(reader, opts, func(r UCRecord) error {
+				result = append(result, r.Query+">"+r.Target)
+				return nil
+			}, nil)).To(Succeed())
+			Expect(result).To(Equal([]string{"q1>c1", "q0>q0", "c1>c1"}))
+
+			Expect(isSearch(modeAuto, true)).To(BeFalse())
+			Expect(isSearch(modeSearch, true)).To(BeTrue())
+			Expect(checkMode(modeDerep)).To(Succeed())
+			Expect(fixedMode(modeDerep)).To(Equal(modeCluster))
+			Expect(checkMode("global")).NotTo(Succeed())
+		})
+
+		It("should not count unmatched queries of searches as mapped in the summary", func() {
+			ucFile := filepath.Join(tmpDir, "search.uc")
+			Expect(os.WriteFile(ucFile, []byte(searchData), 0o644)).To(Succeed())
+
+			summarize := func(mode string) (int, int, int) {
+				input, err := openInputFile(ucFile)
+				Expect(err).NotTo(HaveOccurred())
+				defer input.Close()
+
+				opts := Options{inputFile: ucFile, mode: mode, splitSeqID: true, removeDups: true}
+				_, queries, targets, _, _, unmatched, err := summarizeUC(input, ucFile, opts)
+				Expect(err).NotTo(HaveOccurred())
+				return queries, targets, unmatched
+			}
+
+			queries, targets, unmatched := summarize(modeAuto)
+			Expect([]int{queries, targets, unmatched}).To(Equal([]int{2, 2, 1}))
+			queries, targets, unmatched = summarize(modeCluster)
+			Expect([]int{queries, targets, unmatched}).To(Equal([]int{3, 3, 0}))
+		})
+
+		It("should report unmatched queries of searches in diffs", func() {
+			oldFile := filepath.Join(tmpDir, "old.uc")
+			newFile := filepath.Join(tmpDir, "new.uc")
+			Expect(os.WriteFile(oldFile, []byte("H\t12\t4\t99.0\t+\t0\t0\t4M\tq1\tt1\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(newFile, []byte("N\t*\t4\t*\t*\t*\t*\t*\tq1\t*\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "diff.tsv")
+			var status *exitStatus
+			Expect(errors.As(runDiff([]string{"-a", oldFile, "-b", newFile, "-o", outFile}), &status)).To(BeTrue())
+
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Change\tQuery\tOld\tNew\n" +
+				"record_type\tq1\tH\tN\n" +
+				"target\tq1\tt1\t*\n"))
+		})
+	})
+
+	// ---------- Unmatched queries ----------
+
+	Context("Unmatched queries", func() {
+		const ucData = "S\t0\t4\t*\t*\t*\t*\t*\tseq1\t*\n" +
+			"H\t0\t4\t99.0\t+\t0\t0\t4M\tseq2\tseq1\n" +
+			"N\t*\t4\t*\t*\t*\t*\t*\tseq3;size=2\t*\n" +
+			"N\t*\t4\t*\t*\t*\t*\t*\tseq4\t*\n"
+
+		collect := func(opts Options) []string {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts.inputFile = ucFile
+			reader, err := openRecordReader(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var pairs []string
+			Expect(processRecords(reader, opts, func(r UCRecord) error {
+				pairs = append(pairs, r.Query+">"+r.Target)
+				return nil
+			}, nil)).To(Succeed())
+			return pairs
+		}
+
+		It("should exclude unmatched queries from the mapping and list them", func() {
+			opts := Options{
+				mapOnly:       true,
+				splitSeqID:    true,
+				removeDups:    true,
+				unmatchedFile: filepath.Join(tmpDir, "unmatched.tsv"),
+				unmatched:     newUnmatchedQueries(),
+			}
+			Expect(collect(opts)).To(Equal([]string{"seq1>seq1", "seq2>seq1"}))
+			Expect(writeUnmatched(opts.unmatched, opts)).To(Succeed())
+
+			content, err := os.ReadFile(opts.unmatchedFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("Query\nseq3\nseq4\n"))
+		})
+
+		It("should write unmatched queries as a FASTA subset", func() {
+			seqFile := filepath.Join(tmpDir, "reads.fasta")
+			Expect(os.WriteFile(seqFile, []byte(">seq1\nACGT\n>seq3;size=2\nGGGG\n>seq4 second\nTTTT\n"), 0o644)).To(Succeed())
+
+			opts := Options{
+				mapOnly:       true,
+				splitSeqID:    true,
+				removeDups:    true,
+				querySeqFile:  seqFile,
+				unmatchedFile: filepath.Join(tmpDir, "unmatched.fasta"),
+				unmatched:     newUnmatchedQueries(),
+			}
+			collect(opts)
+			Expect(writeUnmatched(opts.unmatched, opts)).To(Succeed())
+
+			content, err := os.ReadFile(opts.unmatchedFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(">seq3;size=2\nGGGG\n>seq4 second\nTTTT\n"))
+		})
+
+		It("should collect unmatched queries in the summary", func() {
+			ucFile := filepath.Join(tmpDir, "in.uc")
+			Expect(os.WriteFile(ucFile, []byte(ucData), 0o644)).To(Succeed())
+
+			input, err := openInputFile(ucFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts := Options{
+				splitSeqID:    true,
+				removeDups:    true,
+				unmatchedFile: filepath.Join(tmpDir, "unmatched.tsv"),
+				unmatched:     newUnmatchedQueries(),
+			}
+			_, queries, _, _, _, unmatched, err := summarizeUC(input, ucFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queries).To(Equal(2))
+			Expect(unmatched).To(Equal(2))
+			Expect(opts.unmatched.ids).To(Equal([]string{"seq3", "seq4"}))
+		})
+
+		It("should keep N records as self-mappings by default", func() {
+			Expect(collect(Options{mapOnly: true, splitSeqID: true, removeDups: true})).To(Equal(
+				[]string{"seq1>seq1", "seq2>seq1", "seq3>seq3", "seq4>seq4"}))
+		})
+	})
+
+	Context("Tabular input", func() {
+		collect := func(name, data string, opts Options) []string {
+			inputFile := filepath.Join(tmpDir, name)
+			Expect(os.WriteFile(inputFile, []byte(data), 0o644)).To(Succeed())
+
+			input, err := openInputFile(inputFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			opts.inputFile = inputFile
+			reader, err := openRecordReader(input, inputFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var records []string
+			Expect(processRecords(reader, opts, func(r UCRecord) error {
+				records = append(records, r.RecordType+" "+r.Query+">"+r.Target+" "+ucIdentity(r))
+				return nil
+			}, nil)).To(Succeed())
+			return records
+		}
+
+		const blast6Data = "seq1\tref1;size=3\t99.0\t250\t2\t0\t1\t250\t1\t250\t-1\t0\n" +
+			"seq2\t*\t0.0\t0\t0\t0\t0\t0\t0\t0\t-1\t0\n" +
+			"seq3\tref2\t97.5\t250\t6\t0\t1\t250\t1\t250\t-1\t0\n"
+
+		It("should read BLAST6 hits detected from the content", func() {
+			opts := Options{splitSeqID: true, removeDups: true}
+			Expect(collect("hits.txt", blast6Data, opts)).To(Equal([]string{
+				"H seq1>ref1 99.0", "H seq3>ref2 97.5"}))
+		})
+
+		It("should keep BLAST6 no-hit records as unmatched queries", func() {
+			opts := Options{splitSeqID: true, removeDups: true, unmatched: newUnmatchedQueries()}
+			collect("hits.b6", blast6Data, opts)
+			Expect(opts.unmatched.ids).To(Equal([]string{"seq2"}))
+		})
+
+		It("should read userout fields in the given order", func() {
+			opts := Options{splitSeqID: true, removeDups: true, inputOptions: inputOptions{userFields: "target+query+id+qstrand"}}
+			data := "ref1\tseq1\t98.4\t-\n*\tseq2\t*\t*\n"
+			Expect(collect("hits.tsv", data, opts)).To(Equal([]string{"H seq1>ref1 98.4"}))
+		})
+
+		It("should require query and target in userfields", func() {
+			Expect(checkInputFormat(inputOptions{userFields: "query+id"})).To(HaveOccurred())
+			Expect(checkInputFormat(inputOptions{inputFormat: inputUserout})).To(HaveOccurred())
+			Expect(checkInputFormat(inputOptions{inputFormat: "sam"})).To(HaveOccurred())
+		})
+
+		It("should accept the input format in subcommands", func() {
+			inputFile := filepath.Join(tmpDir, "hits.tsv")
+			Expect(os.WriteFile(inputFile, []byte("seq1\tref1\t98.4\nseq2\tref1\t99.0\nseq3\tref2\t97.0\n"), 0o644)).To(Succeed())
+
+			outFile := filepath.Join(tmpDir, "components.tsv")
+			Expect(runComponents([]string{"-i", inputFile, "-a", "-o", outFile, "--userfields", "query+target+id"})).To(Succeed())
+			content, err := os.ReadFile(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(content), "\n")).To(Equal(3))
+
+			Expect(runGraph([]string{"-i", inputFile, "-o", filepath.Join(tmpDir, "g.dot"), "--input-format", "sam"})).To(HaveOccurred())
+		})
+
+		It("should not detect BLAST6 from a first line longer than the buffer", func() {
+			long := strings.Repeat("x", 100)
+			text := bufio.NewReaderSize(strings.NewReader(long+"\t"+strings.Repeat("\t1", 11)+"\n"), 64)
+			Expect(detectInputFormat(text, "-", Options{})).To(Equal(inputUC))
+
+			text = bufio.NewReaderSize(strings.NewReader("seq1\tref1\t99.0"+strings.Repeat("\t1", 9)+"\n"), 64)
+			Expect(detectInputFormat(text, "-", Options{})).To(Equal(inputBlast6))
+		})
+	})
+
+	// ---------- Parquet benchmarks ----------
+
+	Context("Parquet write throughput", Label("benchmark"), func() {
+		opts := Options{
+			inputFile:  testFile,
+			mapOnly:    false,
+			splitSeqID: true,
+			removeDups: true,
+		}
+
+		// Write every record with a separate Write call (previous behaviour)
+		writeRowByRow := func(outFile string) {
+			input, err := openInputFile(opts.inputFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			reader, err := openRecordReader(input, opts.inputFile, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			f, err := os.Create(outFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+
+			writer := parquet.NewGenericWriter[ParquetRecord](f,
+				parquet.Compression(&zstd.Codec{Level: zstd.SpeedBetterCompression}))
+			err = processRecords(reader, opts, func(record UCRecord) error {
+				_, err := writer.Write([]ParquetRecord{record.ToParquet()})
+				return err
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+		}
+
+		// Write records through the batched Parquet path
+		writeBatched := func(outFile string) {
+			input, err := openInputFile(opts.inputFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer input.Close()
+
+			Expect(processAndWriteParquet(input, outFile, opts, nil)).To(Succeed())
+		}
+
+		It("should write the same rows as row-by-row writes", func() {
+			rowFile := filepath.Join(tmpDir, "rows.parquet")
+			batchFile := filepath.Join(tmpDir, "batch.parquet")
+			writeRowByRow(rowFile)
+			writeBatched(batchFile)
+
+			rowRecords, err := parquet.ReadFile[ParquetRecord](rowFile)
+			Expect(err).NotTo(HaveOccurred())
+			batchRecords, err := parquet.ReadFile[ParquetRecord](batchFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(batchRecords).To(HaveLen(len(rowRecords)))
+			Expect(batchRecords).To(Equal(rowRecords))
+		})
+
+		It("should report throughput of row-by-row and batched writes", func() {
+			experiment := gmeasure.NewExperiment("Parquet write throughput")
+			AddReportEntry(experiment.Name, experiment)
+
+			experiment.SampleDuration("row-by-row", func(idx int) {
+				writeRowByRow(filepath.Join(tmpDir, "rows.parquet"))
+			}, gmeasure.SamplingConfig{N: 3})
+
+			experiment.SampleDuration("batched", func(idx int) {
+				writeBatched(filepath.Join(tmpDir, "batch.parquet"))
+			}, gmeasure.SamplingConfig{N: 3})
+
+			rowStats := experiment.GetStats("row-by-row")
+			batchStats := experiment.GetStats("batched")
+			speedup := float64(rowStats.DurationFor(gmeasure.StatMedian)) /
+				float64(batchStats.DurationFor(gmeasure.StatMedian))
+			experiment.RecordValue("speedup", speedup, gmeasure.Precision(2))
+		})
+	})
 })
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Unmatched queries (N records), collected for --unmatched
+type unmatchedQueries struct {
+	ids  []string // In input order
+	seen map[string]struct{}
+}
+
+func newUnmatchedQueries() *unmatchedQueries {
+	return &unmatchedQueries{seen: make(map[string]struct{})}
+}
+
+func (u *unmatchedQueries) add(id string) {
+	if _, exists := u.seen[id]; !exists {
+		u.seen[id] = struct{}{}
+		u.ids = append(u.ids, id)
+	}
+}
+
+// Write unmatched queries as a list, or as a subset of the query sequences (--unmatched-seqs)
+func writeUnmatched(u *unmatchedQueries, opts Options) error {
+	output, err := createOutputFile(opts.unmatchedFile)
+	if err != nil {
+		return newUCError("IO", "failed to create unmatched queries file", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	defer writer.Flush()
+
+	if opts.querySeqFile == "" {
+		if _, err := writer.WriteString("Query\n"); err != nil {
+			return newUCError("IO", "failed to write unmatched queries", err)
+		}
+		for _, id := range u.ids {
+			if _, err := fmt.Fprintln(writer, id); err != nil {
+				return newUCError("IO", "failed to write unmatched queries", err)
+			}
+		}
+		return nil
+	}
+
+	seqInput, err := openInputFile(opts.querySeqFile)
+	if err != nil {
+		return newUCError("IO", "failed to open sequence file", err)
+	}
+	defer seqInput.Close()
+
+	seqs, err := openSeqReader(seqInput, opts.querySeqFile)
+	if err != nil {
+		return newUCError("IO", "failed to read sequence file", err)
+	}
+
+	found := 0
+	for {
+		record, err := seqs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newUCError("IO", "failed to read sequence file", err)
+		}
+		if _, ok := u.seen[splitSeqID(record.ID(), opts.splitSeqID)]; !ok {
+			continue
+		}
+		if err := writeSeqRecord(writer, record, record.header, seqs.fastq); err != nil {
+			return newUCError("IO", "failed to write unmatched queries", err)
+		}
+		found++
+	}
+
+	if missing := len(u.ids) - found; missing > 0 {
+		fmt.Fprintf(os.Stderr, "ucs: %d unmatched queries not found in sequence file\n", missing)
+	}
+	return nil
+}